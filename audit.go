@@ -0,0 +1,276 @@
+package sqliteapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const AuditCreateSql = `
+CREATE TABLE IF NOT EXISTS "gdb_audit" (
+	"id"			INTEGER	PRIMARY KEY AUTOINCREMENT,
+	"createdAt"	DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	"tableName"	TEXT NOT NULL,
+	"rowKey"		TEXT NOT NULL,
+	"action"		TEXT NOT NULL,
+	"username"	TEXT NOT NULL DEFAULT '',
+	"before"		TEXT,
+	"after"		TEXT
+);
+CREATE INDEX IF NOT EXISTS "gdb_audit_row" ON "gdb_audit" ("tableName", "rowKey");`
+
+const (
+	AuditInsert  = "insert"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditRevert  = "revert"
+)
+
+// AuditEntry is a single change to a row, with the row as it was before and after
+// the change (hidden and noaudit fields are not recorded)
+type AuditEntry struct {
+	ID        int64           `db:"id" json:"id"`
+	CreatedAt time.Time       `db:"createdAt" json:"createdAt"`
+	Table     string          `db:"tableName" json:"table"`
+	Key       string          `db:"rowKey" json:"key"`
+	Action    string          `db:"action" json:"action"`
+	Username  string          `db:"username" json:"username"`
+	Before    json.RawMessage `db:"-" json:"before"`
+	After     json.RawMessage `db:"-" json:"after"`
+}
+
+// Audit enables recording every insert, update and delete in the gdb_audit table.
+// Tables with the noAudit option are not recorded.
+func Audit() Option {
+	return func(d *Database) error {
+		_, err := d.DB.Exec(AuditCreateSql)
+		if err != nil {
+			return fmt.Errorf("error creating audit table: %w", err)
+		}
+		d.audit = true
+		return nil
+	}
+}
+
+func (d *Database) isAudited(table string) bool {
+	if !d.audit {
+		return false
+	}
+	if ct := d.getConfig().GetTable(table); ct != nil && ct.NoAudit {
+		return false
+	}
+	ti := d.dbInfo.GetTableInfo(table)
+	return ti != nil && !ti.IsView && ti.GetPrimaryKey().Field != "?"
+}
+
+// keyString returns the key as stored in the audit table, composite keys are
+// comma separated in key order as used in urls
+func keyString(keyValues []interface{}) string {
+	s := make([]string, len(keyValues))
+	for i, v := range keyValues {
+		s[i] = fmt.Sprintf("%v", v)
+	}
+	return strings.Join(s, ",")
+}
+
+// selectRowsWithTx returns all the rows (all fields without labels) matching where
+func (d *Database) selectRowsWithTx(tx *sqlx.Tx, table string, where string, args []interface{}) ([]map[string]interface{}, error) {
+	rows, err := tx.Queryx("SELECT * FROM `"+table+"` WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		m := make(map[string]interface{})
+		err = rows.MapScan(m)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, m)
+	}
+	return ret, rows.Err()
+}
+
+// auditWithTx runs fn and records the changes it made to the rows of table matching
+// where, as selected before fn is run, in the audit trail and for webhooks
+func (d *Database) auditWithTx(tx *sqlx.Tx, table string, action string, where string, args []interface{}, user User, fn func() error) error {
+	if !d.isTracked(table) {
+		return fn()
+	}
+	ti := d.dbInfo.GetTableInfo(table)
+
+	befores, err := d.selectRowsWithTx(tx, table, where, args)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	err = fn()
+	if err != nil {
+		return err
+	}
+
+	for _, before := range befores {
+		keyValues, err := ti.KeyValues(ti.GetKey(before))
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		afters, err := d.selectRowsWithTx(tx, table, ti.KeyWhere(), keyValues)
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		var after map[string]interface{}
+		if len(afters) > 0 {
+			after = afters[0]
+		}
+		err = d.recordChangeWithTx(tx, table, action, keyValues, before, after, user)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditInsertWithTx records the newly inserted row
+func (d *Database) auditInsertWithTx(tx *sqlx.Tx, table string, rowid int64, user User) error {
+	if !d.isTracked(table) {
+		return nil
+	}
+	ti := d.dbInfo.GetTableInfo(table)
+
+	afters, err := d.selectRowsWithTx(tx, table, "rowid=?", []interface{}{rowid})
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	for _, after := range afters {
+		keyValues, err := ti.KeyValues(ti.GetKey(after))
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		err = d.recordChangeWithTx(tx, table, AuditInsert, keyValues, nil, after, user)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTracked returns true if changes to the table are recorded in the audit trail, or
+// are needed for webhooks
+func (d *Database) isTracked(table string) bool {
+	return d.isAudited(table) || d.hasWebhooks(table)
+}
+
+// recordChangeWithTx queues any webhooks for the change, and records it in the audit trail
+func (d *Database) recordChangeWithTx(tx *sqlx.Tx, table string, action string, keyValues []interface{}, before, after map[string]interface{}, user User) error {
+	err := d.queueWebhooksWithTx(tx, table, action, keyValues, before, after)
+	if err != nil {
+		return err
+	}
+	if !d.isAudited(table) {
+		return nil
+	}
+	return d.writeAuditWithTx(tx, table, action, keyValues, before, after, user)
+}
+
+func (d *Database) writeAuditWithTx(tx *sqlx.Tx, table string, action string, keyValues []interface{}, before, after map[string]interface{}, user User) error {
+	toJson := func(m map[string]interface{}) (interface{}, error) {
+		if m == nil {
+			return nil, nil
+		}
+		for k := range m {
+			if !d.isFieldAudited(table, k) {
+				delete(m, k)
+			}
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+
+	b, err := toJson(before)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	a, err := toJson(after)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	username := ""
+	if user != nil {
+		username = user.GetUsername()
+	}
+
+	_, err = tx.Exec("INSERT INTO gdb_audit (tableName, rowKey, action, username, before, after) VALUES (?, ?, ?, ?, ?, ?)",
+		table, keyString(keyValues), action, username, b, a)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+// isFieldAudited returns false for hidden fields (e.g. passwords) and noaudit fields
+func (d *Database) isFieldAudited(table string, field string) bool {
+	if ct := d.getConfig().GetTable(table); ct != nil {
+		for _, f := range ct.Fields {
+			if f.Name == field {
+				return !f.Hidden && !f.NoAudit
+			}
+		}
+	}
+	return true
+}
+
+// History returns the audit entries of the row oldest first
+func (d *Database) History(table string, key interface{}) ([]AuditEntry, error) {
+	ti := d.dbInfo.GetTableInfo(table)
+	if ti == nil {
+		return nil, ErrUnknownTable
+	}
+	keyValues, err := ti.KeyValues(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]AuditEntry, 0)
+	if !d.audit {
+		return ret, nil
+	}
+	rows, err := d.DB.Queryx("SELECT * FROM gdb_audit WHERE tableName=? AND rowKey=? ORDER BY id", table, keyString(keyValues))
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+func scanAuditEntries(rows *sqlx.Rows) ([]AuditEntry, error) {
+	defer rows.Close()
+	ret := make([]AuditEntry, 0)
+	for rows.Next() {
+		var row struct {
+			AuditEntry
+			Before sql.NullString `db:"before"`
+			After  sql.NullString `db:"after"`
+		}
+		err := rows.StructScan(&row)
+		if err != nil {
+			return nil, err
+		}
+		if row.Before.Valid {
+			row.AuditEntry.Before = json.RawMessage(row.Before.String)
+		}
+		if row.After.Valid {
+			row.AuditEntry.After = json.RawMessage(row.After.String)
+		}
+		ret = append(ret, row.AuditEntry)
+	}
+	return ret, rows.Err()
+}
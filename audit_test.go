@@ -0,0 +1,112 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUser string
+
+func (u testUser) IsAdmin() bool       { return false }
+func (u testUser) GetUsername() string { return string(u) }
+
+func TestAudit(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    id:
+    customer:
+    password:
+      hidden: true
+    notes:
+      noaudit: true
+  invoiceItem:
+    id:
+    invoiceId:
+      type: integer
+      ref: invoice.id/customer
+    item:
+  session:
+    noAudit: true
+    id:
+    token:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		Audit(),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	fred := testUser("fred")
+
+	inv := map[string]interface{}{
+		"customer": "Fred Blogs",
+		"password": "secret",
+		"notes":    "note 1",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item A"},
+		},
+	}
+	id, err := db.InsertMap("invoice", inv, fred)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.UpdateMap("invoice", map[string]interface{}{"id": id, "customer": "ACME", "notes": "note 2"}, fred))
+	assert.NoError(t, db.UpdateMap("invoice", map[string]interface{}{"id": id, "notes": "note 3"}, nil))
+
+	_, err = db.InsertMap("session", map[string]interface{}{"token": "abc"}, fred)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Delete("invoice", id, fred))
+
+	history, err := db.History("invoice", id)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 4) {
+		assert.Equal(t, AuditInsert, history[0].Action)
+		assert.Equal(t, "fred", history[0].Username)
+		assert.Nil(t, history[0].Before)
+
+		var before, after map[string]interface{}
+		assert.NoError(t, json.Unmarshal(history[1].Before, &before))
+		assert.NoError(t, json.Unmarshal(history[1].After, &after))
+		assert.Equal(t, AuditUpdate, history[1].Action)
+		assert.Equal(t, "Fred Blogs", before["customer"])
+		assert.Equal(t, "ACME", after["customer"])
+		assert.NotContains(t, after, "password")
+		assert.NotContains(t, after, "notes")
+
+		assert.Equal(t, "", history[2].Username)
+
+		assert.Equal(t, AuditDelete, history[3].Action)
+		assert.Nil(t, history[3].After)
+	}
+
+	// The referencing item is recorded as inserted and deleted
+	history, err = db.History("invoiceItem", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, AuditInsert, history[0].Action)
+		assert.Equal(t, AuditDelete, history[1].Action)
+	}
+
+	history, err = db.History("session", 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 0)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/invoice/1?history")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	entries := make([]AuditEntry, 0)
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&entries))
+	res.Body.Close()
+	assert.Len(t, entries, 4)
+}
@@ -3,6 +3,9 @@ package sqliteapi
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -11,20 +14,84 @@ import (
 
 // Ref: https://github.com/rqlite/rqlite/blob/master/db/db.go#L648
 
+// BackupOptions controls how a backup is copied. Copying in steps lets other
+// connections write to the database between steps.
+type BackupOptions struct {
+	PagesPerStep int           // Pages copied per step, defaults to all in one step
+	StepPause    time.Duration // Pause between steps
+	Progress     func(remaining, total int)
+	Vacuum       bool        // Use VACUUM INTO to write a compacted copy, which is a single step
+	Encrypt      KeyProvider // Encrypt the backup with AES-256-GCM
+}
+
 // Backup writes a consistent snapshot of the database to the given file.
 // This function can be called when changes to the database are in flight.
 func (d *Database) Backup(path string) error {
+	return d.BackupContext(context.Background(), path, nil)
+}
+
+// BackupContext writes a consistent snapshot of the database to the given file. The
+// backup is written to a temporary file which is renamed to path once complete, so a
+// cancelled or failed backup leaves no partial file behind.
+func (d *Database) BackupContext(ctx context.Context, path string, opts *BackupOptions) error {
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create backup database: %s", err)
+	}
+	f.Close()
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	// The unencrypted copy of an encrypted backup is also written beside path, only
+	// readable by the owner
+	plain := tmp
+	if opts.Encrypt != nil {
+		f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.db")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		plain = f.Name()
+		defer os.Remove(plain)
+	}
+
+	if opts.Vacuum {
+		// The temporary file is empty, which VACUUM INTO allows
+		_, err = d.DB.ExecContext(ctx, "VACUUM INTO ?", plain)
+	} else {
+		err = d.backupTo(ctx, plain, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Encrypt != nil {
+		err = EncryptBackup(plain, tmp, opts.Encrypt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (d *Database) backupTo(ctx context.Context, path string, opts *BackupOptions) error {
 	dstDB, err := sqlx.Open("sqlite3", path)
 	if err != nil {
 		return fmt.Errorf("create backup database: %s", err)
 	}
+	defer dstDB.Close()
 
-	dstConn, err := dstDB.Conn(context.Background())
+	dstConn, err := dstDB.Conn(ctx)
 	if err != nil {
 		return err
 	}
 	defer dstConn.Close()
-	srcConn, err := d.DB.DB.Conn(context.Background())
+	srcConn, err := d.DB.DB.Conn(ctx)
 	if err != nil {
 		return err
 	}
@@ -35,7 +102,7 @@ func (d *Database) Backup(path string) error {
 	// Define the backup function.
 	bf := func(driverConn interface{}) error {
 		srcSQLiteConn := driverConn.(*sqlite3.SQLiteConn)
-		return copyDatabaseConnection(dstSQLiteConn, srcSQLiteConn)
+		return copyDatabaseConnection(ctx, dstSQLiteConn, srcSQLiteConn, opts)
 	}
 
 	return dstConn.Raw(
@@ -45,24 +112,43 @@ func (d *Database) Backup(path string) error {
 		})
 }
 
-const bkDelay = 250
+func copyDatabaseConnection(ctx context.Context, dst, src *sqlite3.SQLiteConn, opts *BackupOptions) error {
+	pages := opts.PagesPerStep
+	if pages <= 0 {
+		pages = -1
+	}
 
-func copyDatabaseConnection(dst, src *sqlite3.SQLiteConn) error {
 	bk, err := dst.Backup("main", src, "main")
 	if err != nil {
 		return err
 	}
 
 	for {
-		done, err := bk.Step(-1)
+		done, err := bk.Step(pages)
 		if err != nil {
 			bk.Finish()
 			return err
 		}
+		if opts.Progress != nil {
+			opts.Progress(bk.Remaining(), bk.PageCount())
+		}
 		if done {
 			break
 		}
-		time.Sleep(bkDelay * time.Millisecond)
+
+		// Finishing early rolls back the destination
+		if ctx.Err() != nil {
+			bk.Finish()
+			return ctx.Err()
+		}
+		if opts.StepPause > 0 {
+			select {
+			case <-ctx.Done():
+				bk.Finish()
+				return ctx.Err()
+			case <-time.After(opts.StepPause):
+			}
+		}
 	}
 	return bk.Finish()
 }
@@ -0,0 +1,392 @@
+package sqliteapi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Encrypted backups start with a header of the magic, chunk size, key id and nonce
+// prefix, followed by chunks of a final flag, ciphertext length and the AES-256-GCM
+// ciphertext. Each chunk's nonce is the prefix and chunk number, and the header and
+// final flag are authenticated with it, so reordered, truncated or extended backups
+// fail to decrypt.
+const encryptMagic = "SQLAPIE1"
+
+// EncryptChunkSize is the plaintext size of each chunk of an encrypted backup
+var EncryptChunkSize = 64 * 1024
+
+// maxEncryptChunkSize limits the chunk size read from the (not yet authenticated)
+// header of a backup, as a chunk is read into memory before it is authenticated
+const maxEncryptChunkSize = 16 * 1024 * 1024
+
+// KeyProvider provides the 32 byte AES-256 keys for encrypted backups. The id of the
+// key used is stored in the backup, allowing keys to be rotated.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error) // Used for new backups
+	Key(id string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider with a single key
+type StaticKey []byte
+
+func (k StaticKey) CurrentKey() (string, []byte, error) {
+	return "", k, nil
+}
+
+func (k StaticKey) Key(id string) ([]byte, error) {
+	if id != "" {
+		return nil, fmt.Errorf("unknown key '%s'", id)
+	}
+	return k, nil
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, keys KeyProvider) (*encryptWriter, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encryption key id too long")
+	}
+	if EncryptChunkSize <= 0 || EncryptChunkSize > maxEncryptChunkSize {
+		return nil, fmt.Errorf("encryption chunk size must be 1 to %d bytes", maxEncryptChunkSize)
+	}
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce[:8])
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encryptMagic)+4, len(encryptMagic)+5+len(id)+8)
+	copy(header, encryptMagic)
+	binary.BigEndian.PutUint32(header[len(encryptMagic):], uint32(EncryptChunkSize))
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, nonce[:8]...)
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		nonce:  nonce,
+		buf:    make([]byte, 0, EncryptChunkSize),
+	}, nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, as the last chunk is final
+		if len(e.buf) == EncryptChunkSize {
+			err := e.seal(false)
+			if err != nil {
+				return n - len(p), err
+			}
+		}
+		c := copy(e.buf[len(e.buf):EncryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+	}
+	return n, nil
+}
+
+// Close writes the final chunk, it does not close the underlying writer
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	flag := byte(0)
+	if final {
+		flag = 1
+	}
+	binary.BigEndian.PutUint32(e.nonce[8:], e.counter)
+	e.counter++
+	if e.counter == 0 {
+		return fmt.Errorf("backup too large to encrypt")
+	}
+
+	aad := append(append([]byte{}, e.header...), flag)
+	ct := e.aead.Seal(nil, e.nonce, e.buf, aad)
+	e.buf = e.buf[:0]
+
+	hdr := []byte{flag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(ct)))
+	_, err := e.w.Write(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(ct)
+	return err
+}
+
+type decryptReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	header    []byte
+	nonce     []byte
+	counter   uint32
+	chunkSize int
+	buf       []byte
+	final     bool
+}
+
+func newDecryptReader(r io.Reader, keys KeyProvider) (*decryptReader, error) {
+	header := make([]byte, len(encryptMagic)+5)
+	_, err := io.ReadFull(r, header)
+	if err != nil || string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, fmt.Errorf("%w: not an encrypted backup", ErrInvalidBackup)
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[len(encryptMagic):]))
+	if chunkSize == 0 || chunkSize > maxEncryptChunkSize {
+		return nil, fmt.Errorf("%w: invalid chunk size %d", ErrInvalidBackup, chunkSize)
+	}
+
+	rest := make([]byte, int(header[len(header)-1])+8)
+	_, err = io.ReadFull(r, rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated header", ErrInvalidBackup)
+	}
+	header = append(header, rest...)
+	id := string(rest[:len(rest)-8])
+
+	if keys == nil {
+		return nil, fmt.Errorf("backup is encrypted, no key provider given")
+	}
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, rest[len(rest)-8:])
+
+	return &decryptReader{
+		r:         r,
+		aead:      aead,
+		header:    header,
+		nonce:     nonce,
+		chunkSize: chunkSize,
+	}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.final {
+			return 0, io.EOF
+		}
+		err := dr.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) open() error {
+	hdr := make([]byte, 5)
+	_, err := io.ReadFull(dr.r, hdr)
+	if err != nil {
+		return fmt.Errorf("%w: truncated", ErrInvalidBackup)
+	}
+	flag := hdr[0]
+	size := int(binary.BigEndian.Uint32(hdr[1:]))
+	if flag > 1 || size > dr.chunkSize+dr.aead.Overhead() {
+		return fmt.Errorf("%w: corrupt chunk %d", ErrInvalidBackup, dr.counter)
+	}
+
+	ct := make([]byte, size)
+	_, err = io.ReadFull(dr.r, ct)
+	if err != nil {
+		return fmt.Errorf("%w: truncated", ErrInvalidBackup)
+	}
+
+	binary.BigEndian.PutUint32(dr.nonce[8:], dr.counter)
+	dr.counter++
+	aad := append(append([]byte{}, dr.header...), flag)
+	dr.buf, err = dr.aead.Open(ct[:0], dr.nonce, ct, aad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %s", ErrInvalidBackup, dr.counter-1, err)
+	}
+
+	if flag == 1 {
+		dr.final = true
+		n, _ := dr.r.Read(make([]byte, 1))
+		if n > 0 {
+			return fmt.Errorf("%w: data after final chunk", ErrInvalidBackup)
+		}
+	}
+	return nil
+}
+
+// EncryptBackup writes an encrypted copy of the file src to dst
+func EncryptBackup(src, dst string, keys KeyProvider) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	bw := bufio.NewWriter(out)
+	ew, err := newEncryptWriter(bw, keys)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ew, in)
+	if err != nil {
+		return err
+	}
+	err = ew.Close()
+	if err != nil {
+		return err
+	}
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// DecryptBackup writes the database in the backup src to dst, decrypting and
+// decompressing it as needed
+func DecryptBackup(src, dst string, keys KeyProvider) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := backupReader(in, keys)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// backupReader returns a reader of the database in the backup, which may be
+// encrypted and/or gzipped
+func backupReader(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(encryptMagic))
+	if string(magic) == encryptMagic {
+		dr, err := newDecryptReader(br, keys)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(dr)
+	}
+
+	magic, _ = br.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+		}
+		return zr, nil
+	}
+	return br, nil
+}
+
+// extractBackup writes the database in the backup to a temporary file in
+// BackupTempDir, which the caller must remove
+func extractBackup(path string, keys KeyProvider) (string, error) {
+	f, err := ioutil.TempFile(BackupTempDir, "sqliteapi-restore-*.db")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	err = DecryptBackup(path, f.Name(), keys)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// RestoreEncrypted decrypts (and decompresses) the backup and restores it, see Restore
+func (d *Database) RestoreEncrypted(path string, keys KeyProvider) error {
+	tmp, err := extractBackup(path, keys)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return d.Restore(tmp)
+}
+
+// VerifyBackup checks the backup, which may be encrypted and/or gzipped, can be
+// restored, returning ErrInvalidBackup if not. keys may be nil if the backup is not
+// encrypted.
+func VerifyBackup(path string, keys KeyProvider) error {
+	tmp, err := extractBackup(path, keys)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	_, err = checkRestoreFile(tmp)
+	return err
+}
@@ -0,0 +1,374 @@
+package sqliteapi
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// BackupTimeFormat is the format of {time} in backup filenames, always UTC
+const BackupTimeFormat = "20060102-150405"
+
+var ErrBackupRunning = errors.New("backup already running")
+
+// BackupScheduleConfig configures scheduled backups, which run every Interval or Daily
+// at the given local time e.g. "02:30"
+type BackupScheduleConfig struct {
+	Interval  time.Duration
+	Daily     string
+	Dir       string
+	Filename  string      // Containing {time}, defaults to "backup-{time}.db"
+	Gzip      bool        // Compress the backup, adding .gz to the filename
+	Encrypt   KeyProvider // Encrypt the backup with AES-256-GCM, adding .enc to the filename
+	Retention BackupRetention
+	OnSuccess func(BackupResult)
+	OnFailure func(error)
+}
+
+// BackupRetention is the number of hourly, daily and weekly backups to keep, being the
+// latest backup of each hour, day or (ISO) week. Backups kept by none are removed,
+// and if all are zero all backups are kept.
+type BackupRetention struct {
+	Hourly int
+	Daily  int
+	Weekly int
+}
+
+// BackupResult describes a successful backup
+type BackupResult struct {
+	Path     string
+	Size     int64
+	Duration time.Duration
+	Removed  []string // Old backups removed by the retention policy
+}
+
+type backupScheduler struct {
+	cfg     BackupScheduleConfig
+	daily   time.Time // Time of day
+	running int32
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// BackupSchedule runs backups in the background until the database is closed. Each
+// backup is checked with PRAGMA integrity_check before it replaces any file, and a
+// backup is skipped if the previous one is still running.
+func BackupSchedule(cfg BackupScheduleConfig) Option {
+	return func(d *Database) error {
+		if cfg.Filename == "" {
+			cfg.Filename = "backup-{time}.db"
+		}
+		if !strings.Contains(cfg.Filename, "{time}") {
+			return fmt.Errorf("backup schedule: filename must contain {time}")
+		}
+		if cfg.Dir == "" {
+			return fmt.Errorf("backup schedule: missing dir")
+		}
+		err := os.MkdirAll(cfg.Dir, 0755)
+		if err != nil {
+			return fmt.Errorf("backup schedule: %w", err)
+		}
+
+		s := &backupScheduler{
+			cfg:  cfg,
+			stop: make(chan struct{}),
+		}
+		if cfg.Daily != "" {
+			s.daily, err = time.Parse("15:04", cfg.Daily)
+			if err != nil {
+				return fmt.Errorf("backup schedule: invalid daily time '%s'", cfg.Daily)
+			}
+		} else if cfg.Interval <= 0 {
+			return fmt.Errorf("backup schedule: missing interval or daily time")
+		}
+
+		d.backups = s
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				timer := time.NewTimer(time.Until(s.next(time.Now())))
+				select {
+				case <-s.stop:
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				d.RunScheduledBackup()
+			}
+		}()
+		return nil
+	}
+}
+
+// next returns the time of the next backup after now
+func (s *backupScheduler) next(now time.Time) time.Time {
+	if s.cfg.Daily == "" {
+		return now.Add(s.cfg.Interval)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), s.daily.Hour(), s.daily.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func (d *Database) stopBackupSchedule() {
+	if d.backups != nil {
+		close(d.backups.stop)
+		d.backups.wg.Wait()
+		d.backups = nil
+	}
+}
+
+// RunScheduledBackup runs a scheduled backup now, returning ErrBackupRunning if one is
+// already running. The OnSuccess or OnFailure hook is called with the result.
+func (d *Database) RunScheduledBackup() (*BackupResult, error) {
+	s := d.backups
+	if s == nil {
+		return nil, errors.New("no backup schedule")
+	}
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		d.log.Printf("Backup skipped: %s", ErrBackupRunning)
+		return nil, ErrBackupRunning
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+
+	res, err := d.scheduledBackup(time.Now())
+	if err != nil {
+		d.log.Printf("Backup failed: %s", err)
+		if s.cfg.OnFailure != nil {
+			s.cfg.OnFailure(err)
+		}
+		return nil, err
+	}
+
+	d.log.Printf("Backup written to %s (%d bytes) in %s", res.Path, res.Size, res.Duration)
+	if s.cfg.OnSuccess != nil {
+		s.cfg.OnSuccess(*res)
+	}
+	return res, nil
+}
+
+func (d *Database) scheduledBackup(now time.Time) (*BackupResult, error) {
+	cfg := d.backups.cfg
+
+	name := strings.Replace(cfg.Filename, "{time}", now.UTC().Format(BackupTimeFormat), 1)
+	path := filepath.Join(cfg.Dir, name)
+	if cfg.Gzip {
+		path += ".gz"
+	}
+	if cfg.Encrypt != nil {
+		path += ".enc"
+	}
+
+	// Backup to a temporary file, only replacing path once it has been checked. The
+	// temporary files are only readable by the owner, as they are not yet encrypted.
+	tmp := filepath.Join(cfg.Dir, "."+name+".tmp")
+	defer os.Remove(tmp)
+
+	err := d.Backup(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBackupIntegrity(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	out := tmp
+	if cfg.Gzip {
+		err = gzipFile(out, out+".gz")
+		defer os.Remove(out + ".gz")
+		if err != nil {
+			return nil, err
+		}
+		out += ".gz"
+	}
+	if cfg.Encrypt != nil {
+		enc := filepath.Join(cfg.Dir, "."+name+".tmp.enc")
+		defer os.Remove(enc)
+		err = EncryptBackup(out, enc, cfg.Encrypt)
+		if err != nil {
+			return nil, err
+		}
+		out = enc
+	}
+
+	err = os.Rename(out, path)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &BackupResult{
+		Path:     path,
+		Duration: time.Since(now),
+	}
+	if fi, err := os.Stat(path); err == nil {
+		res.Size = fi.Size()
+	}
+
+	res.Removed, err = d.applyBackupRetention()
+	if err != nil {
+		return nil, fmt.Errorf("retention: %w", err)
+	}
+	return res, nil
+}
+
+// checkBackupIntegrity runs PRAGMA integrity_check on the backup
+func checkBackupIntegrity(path string) error {
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var results []string
+	err = db.Select(&results, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("integrity check: %w", err)
+	}
+	if len(results) != 1 || results[0] != "ok" {
+		return fmt.Errorf("integrity check: %s", strings.Join(results, "; "))
+	}
+	return nil
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// applyBackupRetention removes the backups not kept by the retention policy, returning
+// their paths
+func (d *Database) applyBackupRetention() ([]string, error) {
+	cfg := d.backups.cfg
+	r := cfg.Retention
+	if r.Hourly == 0 && r.Daily == 0 && r.Weekly == 0 {
+		return nil, nil
+	}
+
+	pattern := regexp.QuoteMeta(cfg.Filename)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{time}"), `(\d{8}-\d{6})`, 1)
+	if cfg.Gzip {
+		pattern += `\.gz`
+	}
+	if cfg.Encrypt != nil {
+		pattern += `\.enc`
+	}
+	reg, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		name string
+		t    time.Time
+	}
+	backups := []backup{}
+	for _, f := range files {
+		m := reg.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		t, err := time.Parse(BackupTimeFormat, m[1])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{f.Name(), t})
+	}
+	sort.Slice(backups, func(a, b int) bool {
+		return backups[a].t.After(backups[b].t)
+	})
+
+	times := make([]time.Time, len(backups))
+	for i, b := range backups {
+		times[i] = b.t
+	}
+	keep := backupsToKeep(times, r)
+
+	removed := []string{}
+	for i, b := range backups {
+		if keep[i] {
+			continue
+		}
+		path := filepath.Join(cfg.Dir, b.name)
+		err = os.Remove(path)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// backupsToKeep returns the indexes of the backup times (newest first) to keep
+func backupsToKeep(times []time.Time, r BackupRetention) map[int]bool {
+	keep := make(map[int]bool)
+	if len(times) > 0 {
+		keep[0] = true // Always keep the latest
+	}
+
+	rules := []struct {
+		n      int
+		bucket func(time.Time) string
+	}{
+		{r.Hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{r.Daily, func(t time.Time) string { return t.Format("20060102") }},
+		{r.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", y, w)
+		}},
+	}
+	for _, rule := range rules {
+		n := rule.n
+		last := ""
+		for i, t := range times {
+			if n <= 0 {
+				break
+			}
+			if b := rule.bucket(t); b != last {
+				keep[i] = true
+				last = b
+				n--
+			}
+		}
+	}
+	return keep
+}
@@ -1,9 +1,21 @@
 package sqliteapi
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
@@ -48,3 +60,572 @@ tables:
 	assert.NoError(t, d.Get(&c, "SELECT COUNT(*) FROM test"))
 	assert.Equal(t, c, rows)
 }
+
+func TestBackupSchedule(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backups")
+
+	// Old backups, of which the latest of each hour is kept
+	assert.NoError(t, os.MkdirAll(backupDir, 0755))
+	hour := time.Now().UTC().Truncate(time.Hour)
+	old := []string{}
+	for _, ago := range []time.Duration{30 * time.Minute, 40 * time.Minute, 90 * time.Minute, 150 * time.Minute} {
+		name := filepath.Join(backupDir, "test-"+hour.Add(-ago).Format(BackupTimeFormat)+".db.gz")
+		assert.NoError(t, ioutil.WriteFile(name, []byte{}, 0644))
+		old = append(old, name)
+	}
+	other := filepath.Join(backupDir, "other.db")
+	assert.NoError(t, ioutil.WriteFile(other, []byte{}, 0644))
+
+	successes := make(chan BackupResult, 10)
+	db, err := NewDatabase(filepath.Join(dir, "test.db"),
+		YamlConfig([]byte(`
+tables:
+  test:
+    id:
+    text:
+`)),
+		BackupSchedule(BackupScheduleConfig{
+			Interval:  time.Hour,
+			Dir:       backupDir,
+			Filename:  "test-{time}.db",
+			Gzip:      true,
+			Retention: BackupRetention{Hourly: 3},
+			OnSuccess: func(res BackupResult) { successes <- res },
+		}),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 1"}, nil)
+	assert.NoError(t, err)
+
+	res, err := db.RunScheduledBackup()
+	assert.NoError(t, err)
+	assert.Equal(t, *res, <-successes)
+	assert.True(t, strings.HasSuffix(res.Path, ".db.gz"))
+	assert.ElementsMatch(t, []string{old[1], old[3]}, res.Removed)
+	assert.FileExists(t, old[0])
+	assert.FileExists(t, old[2])
+	assert.FileExists(t, other)
+
+	// The backup is compressed
+	f, err := os.Open(res.Path)
+	assert.NoError(t, err)
+	zr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	f.Close()
+	restored := filepath.Join(dir, "restored.db")
+	assert.NoError(t, ioutil.WriteFile(restored, b, 0644))
+	rdb, err := sqlx.Open("sqlite3", restored)
+	assert.NoError(t, err)
+	var c int
+	assert.NoError(t, rdb.Get(&c, "SELECT COUNT(*) FROM test"))
+	assert.Equal(t, 1, c)
+	rdb.Close()
+
+	// Overlapping runs are skipped
+	atomic.StoreInt32(&db.backups.running, 1)
+	_, err = db.RunScheduledBackup()
+	assert.ErrorIs(t, err, ErrBackupRunning)
+	atomic.StoreInt32(&db.backups.running, 0)
+
+	// Closing stops the schedule, and closing again is harmless
+	db.Close()
+	db.Close()
+}
+
+func TestBackupsToKeep(t *testing.T) {
+	at := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", s)
+		return t
+	}
+	times := []time.Time{
+		at("2026-10-12 10:30"), // Mon
+		at("2026-10-12 10:00"),
+		at("2026-10-12 09:00"),
+		at("2026-10-11 23:00"), // Sun
+		at("2026-10-11 22:00"),
+		at("2026-10-10 12:00"),
+		at("2026-10-04 12:00"), // Previous week
+	}
+	assert.Equal(t, map[int]bool{0: true}, backupsToKeep(times, BackupRetention{}))
+	assert.Equal(t, map[int]bool{0: true, 2: true}, backupsToKeep(times, BackupRetention{Hourly: 2}))
+	assert.Equal(t, map[int]bool{0: true, 3: true, 5: true}, backupsToKeep(times, BackupRetention{Daily: 3}))
+	assert.Equal(t, map[int]bool{0: true, 3: true, 6: true}, backupsToKeep(times, BackupRetention{Weekly: 3}))
+}
+
+func TestBackupScheduleNext(t *testing.T) {
+	s := &backupScheduler{cfg: BackupScheduleConfig{Daily: "02:30"}}
+	s.daily, _ = time.Parse("15:04", "02:30")
+	now := time.Date(2026, 10, 12, 1, 0, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2026, 10, 12, 2, 30, 0, 0, time.Local), s.next(now))
+	now = time.Date(2026, 10, 12, 2, 30, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2026, 10, 13, 2, 30, 0, 0, time.Local), s.next(now))
+
+	s = &backupScheduler{cfg: BackupScheduleConfig{Interval: time.Hour}}
+	assert.Equal(t, now.Add(time.Hour), s.next(now))
+}
+
+// failingWriter fails part way through a download, like a client disconnecting
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+type testAdmin string
+
+func (u testAdmin) IsAdmin() bool       { return true }
+func (u testAdmin) GetUsername() string { return string(u) }
+
+// asAdmin makes every http request by an admin user
+var asAdmin = RequestUser(func(r *http.Request) User { return testAdmin("admin") })
+
+func TestHandleGetBackup(t *testing.T) {
+	defer func(dir string) { BackupTempDir = dir }(BackupTempDir)
+	BackupTempDir = t.TempDir()
+
+	dir := t.TempDir()
+	db, err := NewDatabase(filepath.Join(dir, "test.db"),
+		YamlConfig([]byte(`
+tables:
+  test:
+    id:
+    text:
+`)),
+		asAdmin,
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 1"}, nil)
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	count := func(b []byte) int {
+		path := filepath.Join(dir, "download.db")
+		assert.NoError(t, ioutil.WriteFile(path, b, 0644))
+		bdb, err := sqlx.Open("sqlite3", path)
+		assert.NoError(t, err)
+		defer bdb.Close()
+		var c int
+		assert.NoError(t, bdb.Get(&c, "SELECT COUNT(*) FROM test"))
+		return c
+	}
+
+	res, err := http.Get(ts.URL + "/_backup")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/octet-stream", res.Header.Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="backup-\d{8}-\d{6}\.db"$`, res.Header.Get("Content-Disposition"))
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count(b))
+
+	res, err = http.Get(ts.URL + "/_backup?gzip")
+	assert.NoError(t, err)
+	assert.Regexp(t, `\.db\.gz"$`, res.Header.Get("Content-Disposition"))
+	zr, err := gzip.NewReader(res.Body)
+	assert.NoError(t, err)
+	b, err = ioutil.ReadAll(zr)
+	res.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count(b))
+
+	// Refused unless an admin user is found
+	for _, fn := range []func(r *http.Request) User{
+		nil,
+		func(r *http.Request) User { return nil },
+		func(r *http.Request) User { return testUser("fred") },
+	} {
+		db.requestUser = fn
+		rec := httptest.NewRecorder()
+		db.HandleGetBackup(rec, httptest.NewRequest(http.MethodGet, "/_backup", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+	db.requestUser = func(r *http.Request) User { return testAdmin("admin") }
+
+	// The temp file is removed when the download fails
+	req := httptest.NewRequest(http.MethodGet, "/_backup", nil)
+	db.HandleGetBackup(failingWriter{httptest.NewRecorder()}, req)
+	files, err := ioutil.ReadDir(BackupTempDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	v1 := []byte(`
+tables:
+  test:
+    id:
+    text:
+`)
+	v2 := []byte(`
+tables:
+  test:
+    id:
+    text:
+    note:
+`)
+
+	db, err := NewDatabase(filepath.Join(dir, "test.db"), YamlConfig(v1))
+	assert.NoError(t, err)
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 1"}, nil)
+	assert.NoError(t, err)
+	backup := filepath.Join(dir, "backup.db")
+	assert.NoError(t, db.Backup(backup))
+	db.Close()
+
+	// Reopened with a newer config
+	db, err = NewDatabase(filepath.Join(dir, "test.db"), YamlConfig(v2))
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 2", "note": "New"}, nil)
+	assert.NoError(t, err)
+
+	count := func() int {
+		var c int
+		assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM test"))
+		return c
+	}
+	assert.Equal(t, 2, count())
+
+	// The older backup is migrated to the running config
+	assert.NoError(t, db.Restore(backup))
+	assert.Equal(t, 1, count())
+	assert.True(t, db.dbInfo.GetTableInfo("test").HasField("note"))
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 3", "note": "Migrated"}, nil)
+	assert.NoError(t, err)
+	var version int
+	assert.NoError(t, db.DB.Get(&version, "PRAGMA user_version"))
+	assert.Equal(t, 2, version)
+
+	// A backup of the same version restores its stored config
+	assert.NoError(t, db.Backup(backup))
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 4"}, nil)
+	assert.NoError(t, err)
+	db.config = nil
+	assert.NoError(t, db.Restore(backup))
+	assert.Equal(t, 2, count())
+	if assert.NotNil(t, db.config) {
+		assert.Len(t, db.config.Tables[0].Fields, 3)
+	}
+
+	// Invalid backups are rejected, leaving the database alone
+	notDB := filepath.Join(dir, "not.db")
+	assert.NoError(t, ioutil.WriteFile(notDB, []byte(strings.Repeat("not a database", 100)), 0644))
+	assert.ErrorIs(t, db.Restore(notDB), ErrInvalidBackup)
+
+	noConfig := filepath.Join(dir, "noconfig.db")
+	other, err := sqlx.Open("sqlite3", noConfig)
+	assert.NoError(t, err)
+	_, err = other.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY)")
+	assert.NoError(t, err)
+	other.Close()
+	assert.ErrorIs(t, db.Restore(noConfig), ErrInvalidBackup)
+	assert.Equal(t, 2, count())
+}
+
+func TestHandlePostRestore(t *testing.T) {
+	defer func(dir string) { BackupTempDir = dir }(BackupTempDir)
+	BackupTempDir = t.TempDir()
+
+	dir := t.TempDir()
+	db, err := NewDatabase(filepath.Join(dir, "test.db"),
+		YamlConfig([]byte(`
+tables:
+  test:
+    id:
+    text:
+`)),
+		asAdmin,
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 1"}, nil)
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/_backup?gzip")
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err)
+
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 2"}, nil)
+	assert.NoError(t, err)
+
+	res, err = http.Post(ts.URL+"/_restore?gzip", "application/octet-stream", bytes.NewReader(b))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var c int
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM test"))
+	assert.Equal(t, 1, c)
+
+	res, err = http.Post(ts.URL+"/_restore", "application/octet-stream", strings.NewReader("not a database"))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	defer func(n int64) { RestoreMaxBytes = n }(RestoreMaxBytes)
+	RestoreMaxBytes = 10
+	res, err = http.Post(ts.URL+"/_restore", "application/octet-stream", bytes.NewReader(b))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	db.requestUser = func(r *http.Request) User { return testUser("fred") }
+	res, err = http.Post(ts.URL+"/_restore?gzip", "application/octet-stream", bytes.NewReader(b))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	files, err := ioutil.ReadDir(BackupTempDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+}
+
+func TestRestorePausesWrites(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  test:
+    id:
+    text:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// As held by Restore
+	db.writes.Lock()
+	inserted := make(chan error)
+	go func() {
+		_, err := db.InsertMap("test", map[string]interface{}{"text": "Item 1"}, nil)
+		inserted <- err
+	}()
+	select {
+	case <-inserted:
+		t.Fatal("insert did not wait for the restore")
+	case <-time.After(50 * time.Millisecond):
+	}
+	db.writes.Unlock()
+	assert.NoError(t, <-inserted)
+}
+
+func TestBackupContext(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(filepath.Join(dir, "test.db"),
+		YamlConfig([]byte(`
+tables:
+  test:
+    id:
+    text:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	text := strings.Repeat("x", 1000)
+	for i := 0; i < 200; i++ {
+		_, err = db.InsertMap("test", map[string]interface{}{"text": text}, nil)
+		assert.NoError(t, err)
+	}
+
+	count := func(path string) int {
+		bdb, err := sqlx.Open("sqlite3", path)
+		assert.NoError(t, err)
+		defer bdb.Close()
+		var c int
+		assert.NoError(t, bdb.Get(&c, "SELECT COUNT(*) FROM test"))
+		return c
+	}
+
+	// In steps with progress
+	path := filepath.Join(dir, "backup.db")
+	remaining := []int{}
+	err = db.BackupContext(context.Background(), path, &BackupOptions{
+		PagesPerStep: 10,
+		StepPause:    time.Millisecond,
+		Progress: func(r, total int) {
+			assert.Greater(t, total, 50)
+			remaining = append(remaining, r)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Greater(t, len(remaining), 5)
+	assert.Equal(t, 0, remaining[len(remaining)-1])
+	assert.Equal(t, 200, count(path))
+
+	// Cancelled part way through
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := filepath.Join(dir, "cancelled.db")
+	err = db.BackupContext(ctx, cancelled, &BackupOptions{
+		PagesPerStep: 10,
+		Progress: func(r, total int) {
+			cancel()
+		},
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.ElementsMatch(t, []string{"test.db", "backup.db"}, names)
+
+	// Compacted copy
+	_, err = db.DB.Exec("DELETE FROM test WHERE id > 100")
+	assert.NoError(t, err)
+	vacuumed := filepath.Join(dir, "vacuumed.db")
+	assert.NoError(t, db.BackupContext(context.Background(), vacuumed, &BackupOptions{Vacuum: true}))
+	assert.Equal(t, 100, count(vacuumed))
+	full, err := os.Stat(path)
+	assert.NoError(t, err)
+	compact, err := os.Stat(vacuumed)
+	assert.NoError(t, err)
+	assert.Less(t, compact.Size(), full.Size())
+}
+
+func TestEncryptBackupStream(t *testing.T) {
+	defer func(n int) { EncryptChunkSize = n }(EncryptChunkSize)
+	EncryptChunkSize = 16
+
+	key := StaticKey(bytes.Repeat([]byte{7}, 32))
+	encrypt := func(plain []byte) []byte {
+		var buf bytes.Buffer
+		ew, err := newEncryptWriter(&buf, key)
+		assert.NoError(t, err)
+		_, err = ew.Write(plain)
+		assert.NoError(t, err)
+		assert.NoError(t, ew.Close())
+		return buf.Bytes()
+	}
+	decrypt := func(b []byte, keys KeyProvider) ([]byte, error) {
+		dr, err := newDecryptReader(bytes.NewReader(b), keys)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(dr)
+	}
+
+	for _, n := range []int{0, 1, 16, 17, 48, 100} {
+		plain := make([]byte, n)
+		for i := range plain {
+			plain[i] = byte(i)
+		}
+		b := encrypt(plain)
+		if n >= 16 {
+			assert.False(t, bytes.Contains(b, plain[:8]), n)
+		}
+		got, err := decrypt(b, key)
+		assert.NoError(t, err, n)
+		assert.Equal(t, plain, append([]byte{}, got...), n)
+	}
+
+	b := encrypt([]byte(strings.Repeat("secret data ", 10)))
+
+	// Tampered
+	tampered := append([]byte{}, b...)
+	tampered[len(tampered)-20] ^= 1
+	_, err := decrypt(tampered, key)
+	assert.ErrorIs(t, err, ErrInvalidBackup)
+
+	// Truncated at a chunk boundary, without the final chunk of 120 % 16 bytes
+	_, err = decrypt(b[:len(b)-(5+8+16)], key)
+	assert.ErrorIs(t, err, ErrInvalidBackup)
+
+	// Extended
+	_, err = decrypt(append(append([]byte{}, b...), 0), key)
+	assert.ErrorIs(t, err, ErrInvalidBackup)
+
+	// Wrong key
+	_, err = decrypt(b, StaticKey(bytes.Repeat([]byte{8}, 32)))
+	assert.ErrorIs(t, err, ErrInvalidBackup)
+
+	// A chunk size too large to read before authenticating the chunk
+	huge := append([]byte{}, b...)
+	binary.BigEndian.PutUint32(huge[len(encryptMagic):], 1<<32-1)
+	_, err = decrypt(huge, key)
+	assert.ErrorIs(t, err, ErrInvalidBackup)
+
+	_, err = newEncryptWriter(&bytes.Buffer{}, StaticKey([]byte("short")))
+	assert.Error(t, err)
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	defer func(dir string) { BackupTempDir = dir }(BackupTempDir)
+	BackupTempDir = t.TempDir()
+
+	dir := t.TempDir()
+	db, err := NewDatabase(filepath.Join(dir, "test.db"),
+		YamlConfig([]byte(`
+tables:
+  test:
+    id:
+    text:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 1"}, nil)
+	assert.NoError(t, err)
+
+	key := StaticKey(bytes.Repeat([]byte{1}, 32))
+	path := filepath.Join(dir, "backup.db.enc")
+	assert.NoError(t, db.BackupContext(context.Background(), path, &BackupOptions{Encrypt: key}))
+
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(b, []byte(encryptMagic)))
+	assert.False(t, bytes.Contains(b, []byte("Item 1")))
+
+	assert.NoError(t, VerifyBackup(path, key))
+	assert.ErrorIs(t, VerifyBackup(path, StaticKey(bytes.Repeat([]byte{2}, 32))), ErrInvalidBackup)
+	assert.Error(t, VerifyBackup(path, nil))
+
+	_, err = db.InsertMap("test", map[string]interface{}{"text": "Item 2"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.RestoreEncrypted(path, key))
+	var c int
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM test"))
+	assert.Equal(t, 1, c)
+
+	// Scheduled, gzipped and encrypted
+	backupDir := filepath.Join(dir, "backups")
+	db.backups = &backupScheduler{cfg: BackupScheduleConfig{
+		Dir:      backupDir,
+		Filename: "test-{time}.db",
+		Gzip:     true,
+		Encrypt:  key,
+	}, stop: make(chan struct{})}
+	assert.NoError(t, os.MkdirAll(backupDir, 0755))
+	res, err := db.RunScheduledBackup()
+	assert.NoError(t, err)
+	assert.Regexp(t, `test-\d{8}-\d{6}\.db\.gz\.enc$`, res.Path)
+	assert.NoError(t, VerifyBackup(res.Path, key))
+
+	files, err := ioutil.ReadDir(backupDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	// The unencrypted copies are written beside the backups
+	files, err = ioutil.ReadDir(BackupTempDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+}
@@ -0,0 +1,219 @@
+package sqliteapi
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	BatchInsert   = "insert"
+	BatchUpdate   = "update"
+	BatchDelete   = "delete"
+	BatchFunction = "function"
+)
+
+// BatchOp is a single operation within a batch. Table is the function name
+// for BatchFunction operations. String values in Key and Data of the form
+// "$n.field" are replaced with the field value from the result of the n'th
+// (zero based) operation, and "$n" with its key.
+type BatchOp struct {
+	Op    string                 `json:"op"`
+	Table string                 `json:"table"`
+	Key   interface{}            `json:"key,omitempty"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+// BatchResult is the result of a single batch operation. Data holds the
+// inserted/updated data, or the row as it was before being deleted.
+type BatchResult struct {
+	Op    string                 `json:"op"`
+	Table string                 `json:"table"`
+	Key   interface{}            `json:"key,omitempty"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+var regBatchRef = regexp.MustCompile(`^\$(\d+)(?:\.(\w+))?$`)
+
+// Batch runs all the operations within a single transaction, so either all
+// succeed or none are applied. After hooks are run once the transaction has
+// been committed.
+func (d *Database) Batch(ops []BatchOp, user User) ([]BatchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	results, err := d.batchWithTx(tx, ops, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return nil, err
+	}
+
+	after := make([]HookParams, len(results))
+	for i, res := range results {
+		var action HookAction
+		switch res.Op {
+		case BatchInsert:
+			action = HookAfterInsert
+		case BatchUpdate:
+			action = HookAfterUpdate
+		case BatchDelete:
+			action = HookAfterDelete
+		case BatchFunction:
+			action = HookAfterFunction
+		}
+		after[i] = HookParams{res.Table, res.Key, res.Data, action, tx, user}
+		d.publishHook(after[i])
+	}
+	for _, hp := range after {
+		err = d.runHooks(hp.Table, hp)
+		if err != nil {
+			d.log.Printf("batch: error running after hook: %s", err)
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+func (d *Database) batchWithTx(tx *sqlx.Tx, ops []BatchOp, user User) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, len(ops))
+
+	for i, op := range ops {
+		key, err := resolveBatchRefs(op.Key, results)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		var data map[string]interface{}
+		if op.Data != nil {
+			x, err := resolveBatchRefs(op.Data, results)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			data = x.(map[string]interface{})
+		}
+
+		res := BatchResult{
+			Op:    op.Op,
+			Table: op.Table,
+			Data:  data,
+		}
+
+		switch op.Op {
+		case BatchInsert:
+			if data == nil {
+				return nil, fmt.Errorf("operation %d: no values to store", i)
+			}
+			err = d.runHooks(op.Table, HookParams{op.Table, nil, data, HookBeforeInsert, tx, user})
+			if err == nil {
+				var id int64
+				id, err = d.insertMapWithTx(tx, op.Table, data, user)
+				res.Key = d.insertedKey(op.Table, data, id)
+			}
+
+		case BatchUpdate:
+			if data == nil {
+				return nil, fmt.Errorf("operation %d: no values to store", i)
+			}
+			if key != nil {
+				tableInfo := d.dbInfo.GetTableInfo(op.Table)
+				if tableInfo == nil {
+					return nil, fmt.Errorf("operation %d: %w", i, ErrUnknownTable)
+				}
+				err = tableInfo.SetKey(data, key)
+				if err != nil {
+					return nil, fmt.Errorf("operation %d: %w", i, err)
+				}
+			}
+			res.Key, err = d.updateMapWithTx(tx, op.Table, data, user)
+
+		case BatchDelete:
+			res.Key = key
+			res.Data, err = d.deleteWithTx(tx, op.Table, key, user, false)
+
+		case BatchFunction:
+			err = d.callFunctionWithTx(tx, op.Table, data, user)
+
+		default:
+			err = fmt.Errorf("unknown operation '%s'", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s: %w", i, op.Table, err)
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// resolveBatchRefs returns a copy of v with all "$n.field" strings replaced
+// with values from the earlier results
+func resolveBatchRefs(v interface{}, results []BatchResult) (interface{}, error) {
+	switch x := v.(type) {
+	case string:
+		m := regBatchRef.FindStringSubmatch(x)
+		if m == nil {
+			return x, nil
+		}
+		n, _ := strconv.Atoi(m[1])
+		if n >= len(results) {
+			return nil, fmt.Errorf("reference '%s' to a later or unknown operation", x)
+		}
+		if m[2] == "" {
+			return results[n].Key, nil
+		}
+		value, ok := results[n].Data[m[2]]
+		if !ok {
+			return nil, fmt.Errorf("reference '%s' to an unknown field", x)
+		}
+		return value, nil
+
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(x))
+		for k, y := range x {
+			z, err := resolveBatchRefs(y, results)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = z
+		}
+		return ret, nil
+
+	case []map[string]interface{}:
+		ret := make([]map[string]interface{}, len(x))
+		for i, y := range x {
+			z, err := resolveBatchRefs(y, results)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = z.(map[string]interface{})
+		}
+		return ret, nil
+
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for i, y := range x {
+			z, err := resolveBatchRefs(y, results)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = z
+		}
+		return ret, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,95 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    id:
+    customer:
+      notnull: true
+    paid:
+      type: integer
+      default: 0
+  payment:
+    id:
+    invoiceRef:
+      type: integer
+      notnull: true
+    amount:
+      type: real
+functions:
+  markPaid:
+    params:
+      invoiceId:
+    statements:
+      - UPDATE invoice SET paid=1 WHERE id=$invoiceId
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	results, err := db.Batch([]BatchOp{
+		{Op: BatchInsert, Table: "invoice", Data: map[string]interface{}{"customer": "Fred"}},
+		{Op: BatchInsert, Table: "payment", Data: map[string]interface{}{"invoiceRef": "$0.id", "amount": 9.99}},
+		{Op: BatchFunction, Table: "markPaid", Data: map[string]interface{}{"invoiceId": "$0"}},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, int64(1), results[0].Key)
+	assert.Equal(t, int64(1), results[1].Data["invoiceRef"])
+
+	m, err := db.GetMap("invoice", 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), m["paid"])
+
+	// A failing operation rolls back the earlier operations
+	_, err = db.Batch([]BatchOp{
+		{Op: BatchInsert, Table: "invoice", Data: map[string]interface{}{"customer": "Bob"}},
+		{Op: BatchUpdate, Table: "invoice", Key: 99, Data: map[string]interface{}{"customer": "Nobody"}},
+	}, nil)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	var c int
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM invoice"))
+	assert.Equal(t, 1, c)
+
+	// References to later operations are rejected
+	_, err = db.Batch([]BatchOp{
+		{Op: BatchInsert, Table: "payment", Data: map[string]interface{}{"invoiceRef": "$1.id"}},
+	}, nil)
+	assert.Error(t, err)
+
+	// Over http
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	body := `[
+		{"op":"update","table":"invoice","key":1,"data":{"customer":"Fred Bloggs"}},
+		{"op":"delete","table":"payment","key":1}
+	]`
+	res, err := http.Post(ts.URL+"/_batch", "application/json", bytes.NewBufferString(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	httpResults := make([]BatchResult, 0)
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&httpResults))
+	res.Body.Close()
+	assert.Len(t, httpResults, 2)
+	assert.Equal(t, 9.99, httpResults[1].Data["amount"])
+
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM payment"))
+	assert.Equal(t, 0, c)
+}
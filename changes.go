@@ -0,0 +1,367 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ChangesTable is the sequenced log of the rows changed in every managed table
+const ChangesTable = "gdb_changes"
+
+const ChangesCreateSql = `
+CREATE TABLE IF NOT EXISTS "gdb_changes" (
+	"seq"			INTEGER	PRIMARY KEY AUTOINCREMENT,
+	"createdAt"	DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	"tableName"	TEXT NOT NULL,
+	"rowKey"		TEXT NOT NULL,
+	"action"		TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS "gdb_changes_row" ON "gdb_changes" ("tableName", "rowKey");
+CREATE TABLE IF NOT EXISTS "gdb_changes_compacted" (
+	"seq"			INTEGER NOT NULL,
+	"compactedAt"	DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);`
+
+// ChangeUpsert is the action of a Change to a row that exists, see ChangeDelete
+const ChangeUpsert = "upsert"
+
+// ChangesLimit is the default maximum number of changes returned by Changes
+var ChangesLimit = 1000
+
+var (
+	ErrNoChangeLog      = errors.New("change log is not enabled")
+	ErrChangesCompacted = errors.New("changes have been compacted, a full sync is required")
+)
+
+// Change is the current state of a changed row, Action is upsert with the row data or
+// delete (a tombstone) if the row no longer exists or is soft deleted
+type Change struct {
+	Seq    int64       `json:"seq"`
+	Table  string      `json:"table"`
+	Action string      `json:"action"`
+	Key    interface{} `json:"key"`
+	Data   Map         `json:"data,omitempty"`
+}
+
+// ChangeSet is the result of Changes, LastSeq being the since value for the next call
+type ChangeSet struct {
+	Changes []Change `json:"changes"`
+	LastSeq int64    `json:"lastSeq"`
+	More    bool     `json:"more"` // Call again with LastSeq for the rest
+}
+
+// ChangeLog enables recording the sequence of changes to every managed table in the
+// gdb_changes table, for clients to incrementally sync using Changes
+func ChangeLog() Option {
+	return func(d *Database) error {
+		d.changeLog = true
+		if d.getConfig() != nil {
+			// Add the triggers to the already applied config
+			return d.ApplyConfig(d.getConfig(), &ConfigOptions{RetainUnmanaged: true})
+		}
+		return nil
+	}
+}
+
+// changeTriggers returns the triggers that log the changes to the table
+func (table *ConfigTable) changeTriggers() []ConfigTrigger {
+	pks := table.PrimaryKeys()
+	if len(pks) == 0 {
+		return nil
+	}
+	oldKey := "json_array(old.`" + strings.Join(pks, "`,old.`") + "`)"
+	newKey := "json_array(new.`" + strings.Join(pks, "`,new.`") + "`)"
+	insert := func(key, action string) string {
+		return "INSERT INTO `" + ChangesTable + "` (`tableName`,`rowKey`,`action`) VALUES ('" +
+			table.Name + "'," + key + ",'" + action + "');"
+	}
+
+	// Changing the key deletes the row with the old key
+	keyChange := "INSERT INTO `" + ChangesTable + "` (`tableName`,`rowKey`,`action`) SELECT '" +
+		table.Name + "'," + oldKey + ",'" + AuditDelete + "' WHERE " + oldKey + " IS NOT " + newKey + ";"
+
+	name := ChangesTable + "_" + table.Name
+	return []ConfigTrigger{
+		{
+			Name:      name + "_insert",
+			Event:     "AFTER INSERT",
+			Table:     table.Name,
+			Statement: insert(newKey, AuditInsert),
+		},
+		{
+			Name:      name + "_update",
+			Event:     "AFTER UPDATE",
+			Table:     table.Name,
+			Statement: keyChange + "\n\t" + insert(newKey, AuditUpdate),
+		},
+		{
+			Name:      name + "_delete",
+			Event:     "AFTER DELETE",
+			Table:     table.Name,
+			Statement: insert(oldKey, AuditDelete),
+		},
+	}
+}
+
+// changeTriggers returns the change log triggers for all the tables
+func (c *Config) changeTriggers() []ConfigTrigger {
+	ret := make([]ConfigTrigger, 0)
+	for _, t := range c.Tables {
+		ret = append(ret, t.changeTriggers()...)
+	}
+	return ret
+}
+
+// applyChangesTableWithTx creates the change log if needed, starting it with the
+// existing rows. Returns a description of the change or "" if there was none.
+func applyChangesTableWithTx(tx *sqlx.Tx, c *Config, slog func(string) string) (string, error) {
+	var n int
+	err := tx.Get(&n, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", ChangesTable)
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		return "", nil
+	}
+
+	_, err = tx.Exec(slog(ChangesCreateSql))
+	if err != nil {
+		return "", err
+	}
+	for _, t := range c.Tables {
+		pks := t.PrimaryKeys()
+		if len(pks) == 0 {
+			continue
+		}
+		s := "INSERT INTO `" + ChangesTable + "` (`tableName`,`rowKey`,`action`)"
+		s += " SELECT '" + t.Name + "',json_array(`" + strings.Join(pks, "`,`") + "`),'" + AuditInsert + "'"
+		s += " FROM `" + t.Name + "`"
+		_, err = tx.Exec(slog(s))
+		if err != nil {
+			return "", err
+		}
+	}
+	return "created change log " + ChangesTable, nil
+}
+
+// Changes returns, in sequence order, the rows of the given tables (or all tables) changed
+// after the sequence number since, each once with its current data. Use 0 to get all the
+// rows, and then the returned LastSeq for the next call. Returns ErrChangesCompacted if
+// tombstones after since have been removed by CompactChanges.
+func (d *Database) Changes(since int64, limit int, tables ...string) (*ChangeSet, error) {
+	if !d.changeLog {
+		return nil, ErrNoChangeLog
+	}
+	if limit <= 0 {
+		limit = ChangesLimit
+	}
+
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // This is a query so we always rollback
+
+	var horizon int64
+	err = tx.Get(&horizon, "SELECT IFNULL(MAX(seq), 0) FROM gdb_changes_compacted")
+	if err != nil {
+		return nil, err
+	}
+	if since > 0 && since < horizon {
+		return nil, ErrChangesCompacted
+	}
+
+	ret := &ChangeSet{
+		Changes: make([]Change, 0),
+	}
+	err = tx.Get(&ret.LastSeq, "SELECT IFNULL(MAX(seq), ?) FROM "+ChangesTable, since)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, more, err := changeLogWithTx(tx, since, limit, tables)
+	if err != nil {
+		return nil, err
+	}
+	if more {
+		ret.More = true
+		ret.LastSeq = entries[len(entries)-1].seq
+	}
+
+	for _, e := range entries {
+		change, err := d.changeWithTx(tx, e)
+		if err != nil {
+			return nil, fmt.Errorf("change %d: %w", e.seq, err)
+		}
+		ret.Changes = append(ret.Changes, change)
+	}
+
+	return ret, nil
+}
+
+// changeLogEntry is the last change to a row in the change log
+type changeLogEntry struct {
+	seq       int64
+	table     string
+	keyValues []interface{}
+}
+
+// changeLogWithTx returns up to limit rows of the tables (or all tables) changed after
+// since, once each in the order of their last change, and if there are more
+func changeLogWithTx(tx *sqlx.Tx, since int64, limit int, tables []string) ([]changeLogEntry, bool, error) {
+	q := "SELECT MAX(seq), tableName, rowKey FROM " + ChangesTable + " WHERE seq>?"
+	args := []interface{}{since}
+	if len(tables) > 0 {
+		q += " AND tableName IN (?" + strings.Repeat(",?", len(tables)-1) + ")"
+		for _, t := range tables {
+			args = append(args, t)
+		}
+	}
+	q += fmt.Sprintf(" GROUP BY tableName, rowKey ORDER BY 1 LIMIT %d", limit+1)
+	return scanChangeLog(tx, limit, q, args...)
+}
+
+// scanChangeLog runs the query of seq, tableName and rowKey, returning up to limit entries
+// and if there are more
+func scanChangeLog(q sqlx.Queryer, limit int, query string, args ...interface{}) ([]changeLogEntry, bool, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	ret := make([]changeLogEntry, 0)
+	for rows.Next() {
+		var e changeLogEntry
+		var rowKey []byte
+		err = rows.Scan(&e.seq, &e.table, &rowKey)
+		if err != nil {
+			return nil, false, err
+		}
+		e.keyValues, err = decodeRowKey(rowKey)
+		if err != nil {
+			return nil, false, fmt.Errorf("change %d: %w", e.seq, err)
+		}
+		ret = append(ret, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if limit > 0 && len(ret) > limit {
+		return ret[:limit], true, nil
+	}
+	return ret, false, nil
+}
+
+// decodeRowKey decodes the json array of key values stored in the change log
+func decodeRowKey(rowKey []byte) ([]interface{}, error) {
+	var keyValues []interface{}
+	dec := json.NewDecoder(bytes.NewReader(rowKey))
+	dec.UseNumber()
+	err := dec.Decode(&keyValues)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range keyValues {
+		if n, ok := v.(json.Number); ok {
+			if i64, err := n.Int64(); err == nil {
+				keyValues[i] = i64
+			} else if f, err := n.Float64(); err == nil {
+				keyValues[i] = f
+			}
+		}
+	}
+	return keyValues, nil
+}
+
+// keyFromValues returns the key values as a single value, or a slice for composite keys
+func keyFromValues(keyValues []interface{}) interface{} {
+	if len(keyValues) == 1 {
+		return keyValues[0]
+	}
+	return keyValues
+}
+
+// changeWithTx returns the change with the current data of the row
+func (d *Database) changeWithTx(tx *sqlx.Tx, e changeLogEntry) (Change, error) {
+	change := Change{
+		Seq:    e.seq,
+		Table:  e.table,
+		Action: ChangeDelete,
+		Key:    keyFromValues(e.keyValues),
+	}
+
+	if d.dbInfo.GetTableInfo(e.table) == nil {
+		return change, nil // The table has since been removed
+	}
+
+	data, err := d.getMapWithTx(tx, e.table, e.keyValues, GetOptions{})
+	if errors.Is(err, sql.ErrNoRows) {
+		return change, nil // Deleted, or soft deleted
+	}
+	if err != nil {
+		return change, err
+	}
+	change.Action = ChangeUpsert
+	change.Data = d.readableData(e.table, data)
+	return change, nil
+}
+
+// CompactChanges removes the change log entries superseded by a later change to the same
+// row, which are never returned by Changes, and if maxAge > 0 the tombstones older than
+// maxAge. Clients that last synced before a removed tombstone will need a full sync. Returns
+// the number of entries removed.
+func (d *Database) CompactChanges(maxAge time.Duration) (int64, error) {
+	if !d.changeLog {
+		return 0, ErrNoChangeLog
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM " + ChangesTable + " WHERE seq NOT IN (SELECT MAX(seq) FROM " + ChangesTable + " GROUP BY tableName, rowKey)")
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := res.RowsAffected()
+
+	if maxAge > 0 {
+		where := " WHERE action=? AND createdAt < strftime('%Y-%m-%d %H:%M:%f', 'now', ?)"
+		args := []interface{}{AuditDelete, fmt.Sprintf("-%f seconds", maxAge.Seconds())}
+
+		var horizon int64
+		err = tx.Get(&horizon, "SELECT IFNULL(MAX(seq), 0) FROM "+ChangesTable+where, args...)
+		if err != nil {
+			return 0, err
+		}
+		if horizon > 0 {
+			res, err = tx.Exec("DELETE FROM "+ChangesTable+where, args...)
+			if err != nil {
+				return 0, err
+			}
+			n, _ := res.RowsAffected()
+			removed += n
+			_, err = tx.Exec("INSERT INTO gdb_changes_compacted (seq) VALUES (?)", horizon)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return removed, tx.Commit()
+}
@@ -0,0 +1,146 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+    secret:
+      hidden: true
+  invoice:
+    softDelete: true
+    id:
+    customerId:
+      type: integer
+      ref: customer.id/name
+  tag:
+    customerId:
+      type: integer
+      pk: 1
+    name:
+      pk: 2
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Changes(0, 0)
+	assert.ErrorIs(t, err, ErrNoChangeLog)
+
+	// Existing rows are included when the change log is enabled
+	fred, err := db.InsertMap("customer", map[string]interface{}{"name": "Fred", "secret": "xyz"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ChangeLog()(db))
+
+	cs, err := db.Changes(0, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, cs.Changes, 1) {
+		assert.Equal(t, ChangeUpsert, cs.Changes[0].Action)
+		assert.Equal(t, "customer", cs.Changes[0].Table)
+		assert.Equal(t, fred, cs.Changes[0].Key)
+		assert.Equal(t, "Fred", cs.Changes[0].Data["name"])
+		assert.NotContains(t, cs.Changes[0].Data, "secret")
+	}
+	assert.False(t, cs.More)
+	since := cs.LastSeq
+
+	bob, err := db.InsertMap("customer", map[string]interface{}{"name": "Bob"}, nil)
+	assert.NoError(t, err)
+	inv, err := db.InsertMap("invoice", map[string]interface{}{"customerId": fred}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.UpdateMap("customer", map[string]interface{}{"id": fred, "name": "ACME"}, nil))
+	_, err = db.InsertMap("tag", map[string]interface{}{"customerId": fred, "name": "vip"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Delete("customer", bob, nil))
+
+	// Each row once, in the order of their last change, with the current data
+	cs, err = db.Changes(since, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, cs.Changes, 4) {
+		assert.Equal(t, "invoice", cs.Changes[0].Table)
+		assert.Equal(t, inv, cs.Changes[0].Key)
+		assert.Equal(t, "ACME", cs.Changes[0].Data["customerId_RefLabel"]) // Current label
+		assert.Equal(t, fred, cs.Changes[1].Key)
+		assert.Equal(t, "ACME", cs.Changes[1].Data["name"])
+		assert.Equal(t, []interface{}{fred, "vip"}, cs.Changes[2].Key)
+		assert.Equal(t, ChangeDelete, cs.Changes[3].Action)
+		assert.Equal(t, bob, cs.Changes[3].Key)
+		assert.Nil(t, cs.Changes[3].Data)
+		assert.Equal(t, cs.Changes[3].Seq, cs.LastSeq)
+	}
+
+	// Paged
+	cs, err = db.Changes(since, 3)
+	assert.NoError(t, err)
+	assert.Len(t, cs.Changes, 3)
+	assert.True(t, cs.More)
+	cs, err = db.Changes(cs.LastSeq, 3)
+	assert.NoError(t, err)
+	assert.Len(t, cs.Changes, 1)
+	assert.False(t, cs.More)
+
+	// Only the given tables
+	cs, err = db.Changes(0, 0, "tag")
+	assert.NoError(t, err)
+	assert.Len(t, cs.Changes, 1)
+
+	// Soft deleted rows are tombstones
+	since = cs.LastSeq
+	assert.NoError(t, db.Delete("invoice", inv, nil))
+	cs, err = db.Changes(since, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, cs.Changes, 1) {
+		assert.Equal(t, "invoice", cs.Changes[0].Table)
+		assert.Equal(t, ChangeDelete, cs.Changes[0].Action)
+	}
+
+	// Compacting superseded entries does not change the result
+	before, err := db.Changes(0, 0)
+	assert.NoError(t, err)
+	removed, err := db.CompactChanges(0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), removed)
+	after, err := db.Changes(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + fmt.Sprintf("/_changes?since=%d", since))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var got ChangeSet
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	res.Body.Close()
+	assert.Len(t, got.Changes, 1)
+
+	// Removing old tombstones requires clients that synced before them to do a full sync
+	time.Sleep(10 * time.Millisecond)
+	removed, err = db.CompactChanges(time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+	_, err = db.Changes(1, 0)
+	assert.ErrorIs(t, err, ErrChangesCompacted)
+	cs, err = db.Changes(0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, cs.Changes, 3)
+
+	res, err = http.Get(ts.URL + "/_changes?since=1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+	res.Body.Close()
+}
@@ -0,0 +1,155 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompositeKey(t *testing.T) {
+	const yaml = `
+tables:
+  t1:
+    id:
+    title:
+  t2:
+    id:
+    title2:
+  t1T2:
+    t1Id:
+      type: integer
+      pk: 1
+      notnull: true
+      ref: t1.id/title
+    t2Id:
+      type: integer
+      pk: 2
+      notnull: true
+      ref: t2.id/title2
+    note:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ti := db.dbInfo.GetTableInfo("t1T2")
+	assert.Equal(t, []string{"t1Id", "t2Id"}, ti.GetPrimaryKeyFields())
+	assert.False(t, ti.IsPrimaryKeyId)
+
+	for _, title := range []string{"T2 1", "T2 2", "T2 3"} {
+		_, err = db.InsertMap("t2", map[string]interface{}{"title2": title}, nil)
+		assert.NoError(t, err)
+	}
+
+	id, err := db.InsertMap("t1", map[string]interface{}{
+		"title": "T1 1",
+		"t1T2" + RefTableSuffix: []map[string]interface{}{
+			{"t2Id": 1, "note": "a"},
+			{"t2Id": 2, "note": "b"},
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	m, err := db.GetMap("t1T2", []interface{}{id, 2}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", m["note"])
+	assert.Equal(t, "T2 2", m["t2Id"+RefLabelSuffix])
+
+	m, err = db.GetMap("t1T2", "1,1", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", m["note"])
+
+	_, err = db.GetMap("t1T2", 1, false)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	assert.NoError(t, db.UpdateMap("t1T2", map[string]interface{}{"t1Id": 1, "t2Id": 1, "note": "A"}, nil))
+	m, err = db.GetMap("t1T2", "t2Id=1;t1Id=1", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "A", m["note"])
+
+	// Only part of the key
+	assert.ErrorIs(t, db.UpdateMap("t1T2", map[string]interface{}{"t1Id": 1, "note": "X"}, nil), ErrInvalidKey)
+
+	m, err = db.GetMap("t1", id, true)
+	assert.NoError(t, err)
+	assert.Len(t, m["t1T2"+RefTableSuffix], 2)
+
+	// Over http
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+	client := &http.Client{}
+
+	res, err := http.Post(ts.URL+"/t1T2", "application/json", bytes.NewBufferString(`{"t1Id":1,"t2Id":3,"note":"c"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/t1T2/1,3", bytes.NewBufferString(`{"note":"C"}`))
+	assert.NoError(t, err)
+	res, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	res, err = http.Get(ts.URL + "/t1T2/t1Id=1;t2Id=3")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	m = make(map[string]interface{})
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&m))
+	res.Body.Close()
+	assert.Equal(t, "C", m["note"])
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/t1T2/1,2", nil)
+	assert.NoError(t, err)
+	res, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	var c int
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM t1T2"))
+	assert.Equal(t, 2, c)
+
+	// Deleting the parent removes the join table rows
+	assert.NoError(t, db.Delete("t1", id, nil))
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM t1T2"))
+	assert.Equal(t, 0, c)
+}
+
+func TestCompositeKeyReferences(t *testing.T) {
+	const yaml = `
+tables:
+  orderLine:
+    orderId:
+      type: integer
+      pk: 1
+    lineNo:
+      type: integer
+      pk: 2
+    code:
+      unique: true
+  note:
+    id:
+    lineCode:
+      ref: orderLine.code
+`
+	// A unique field of the table can be referenced
+	c, err := NewConfigFromYaml([]byte(yaml))
+	assert.NoError(t, err)
+	assert.Len(t, c.GetBackReferences("orderLine"), 1)
+
+	_, err = NewConfigFromYaml([]byte(yaml + `
+    orderId:
+      type: integer
+      ref: orderLine.orderId
+`))
+	assert.EqualError(t, err, "note.orderId: references part of the composite primary key of orderLine")
+}
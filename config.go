@@ -26,6 +26,12 @@ var SpecialFields = map[string]ConfigField{
 		Default:  "CURRENT_TIMESTAMP",
 		ReadOnly: true,
 	},
+	DeletedAtField: {
+		Name:     DeletedAtField,
+		Type:     "DATETIME",
+		Indexed:  true,
+		ReadOnly: true,
+	},
 }
 
 const (
@@ -33,6 +39,7 @@ const (
 	TypeInteger = "integer"
 	TypeReal    = "real"
 	TypeBlob    = "blob"
+	TypeJSON    = "json"
 )
 
 type Config struct {
@@ -40,11 +47,19 @@ type Config struct {
 	Triggers  []ConfigTrigger  `yaml:"triggers,omitempty"`
 	Functions []ConfigFunction `yaml:"functions,omitempty"`
 	Views     []ConfigView     `yaml:"views,omitempty"`
+	Webhooks  []ConfigWebhook  `yaml:"webhooks,omitempty"`
 }
 
 type ConfigTable struct {
 	Name   string        `yaml:"name"`
 	Fields []ConfigField `yaml:"fields"`
+
+	// Options
+	SoftDelete bool `yaml:"softDelete,omitempty"` // Set deletedAt instead of deleting rows
+	NoAudit    bool `yaml:"noAudit,omitempty"`    // Exclude from the audit trail
+	Temporal   bool `yaml:"temporal,omitempty"`   // Keep a history of the rows for asOf queries
+
+	Seed *ConfigSeed `yaml:"seed,omitempty"`
 }
 
 type ConfigTrigger struct {
@@ -66,6 +81,16 @@ type ConfigView struct {
 	Statement string `yaml:"statement"`
 }
 
+type ConfigWebhook struct {
+	Name        string   `yaml:"name"`
+	URL         string   `yaml:"url"`
+	Tables      []string `yaml:"tables,omitempty"`
+	Functions   []string `yaml:"functions,omitempty"`
+	Events      []string `yaml:"events,omitempty"` // insert, update, delete, function (default all)
+	Secret      string   `yaml:"secret,omitempty"` // Signs the payload using HMAC-SHA256
+	MaxAttempts int      `yaml:"maxAttempts,omitempty"`
+}
+
 type ConfigFunctionParam struct {
 	Name    string `yaml:"name"`
 	Notnull bool   `yaml:"notnull"`
@@ -124,6 +149,7 @@ type ConfigField struct {
 	References string      `yaml:"ref" json:"ref,omitempty"` // e.g. driver.id // FOREIGN KEY("driverId") REFERENCES "driver"("id")
 	PrimaryKey int         `yaml:"pk" json:"-"`
 	Unique     bool        `yaml:"unique" json:"unique,omitempty"`
+	Generate   string      `yaml:"generate,omitempty" json:"generate,omitempty"` // uuid, ulid or nanoid
 
 	// Indirectly database related
 	Indexed bool `yaml:"indexed" json:"indexed,omitempty"`
@@ -133,6 +159,7 @@ type ConfigField struct {
 	Hidden   bool   `yaml:"hidden" json:"hidden,omitempty"`
 	ReadOnly bool   `yaml:"readonly" json:"readonly,omitempty"`
 	Hint     string `yaml:"hint" json:"hint,omitempty"`
+	NoAudit  bool   `yaml:"noaudit,omitempty" json:"noaudit,omitempty"`
 
 	// User interface
 	Control string `yaml:"control" json:"control,omitempty"`
@@ -221,6 +248,12 @@ func (c *Config) String() string {
 		s += "\n"
 	}
 
+	for _, w := range c.Webhooks {
+		s += dashes
+		s += fmt.Sprintf("Webhook %s: %s\n\tTables: %s\n\tFunctions: %s\n",
+			w.Name, w.URL, strings.Join(w.Tables, ", "), strings.Join(w.Functions, ", "))
+	}
+
 	return s
 }
 
@@ -272,8 +305,9 @@ func (trigger *ConfigTrigger) CreateSQL() string {
 func (table *ConfigTable) CreateSQL() (string, error) {
 	coldefs := []string{}
 	forkeys := []string{}
+	pks := table.PrimaryKeys()
 	for _, f := range table.Fields {
-		coldef, err := f.ColDef()
+		coldef, err := f.colDef(len(pks) == 1)
 		if err != nil {
 			return "", err
 		}
@@ -288,6 +322,10 @@ func (table *ConfigTable) CreateSQL() (string, error) {
 		}
 	}
 
+	if len(pks) > 1 { // Composite primary key
+		coldefs = append(coldefs, "PRIMARY KEY(`"+strings.Join(pks, "`,`")+"`)")
+	}
+
 	sql := "CREATE TABLE \"" + table.Name + "\" (\n\t"
 	sql += strings.Join(append(coldefs, forkeys...), ",\n\t")
 	sql += ")"
@@ -295,13 +333,65 @@ func (table *ConfigTable) CreateSQL() (string, error) {
 	return sql, nil
 }
 
+// setOption sets a table level option from the yaml config
+func (table *ConfigTable) setOption(name string, value interface{}) error {
+	var err error
+	switch name {
+	case "softDelete":
+		table.SoftDelete, err = toBool(value)
+	case "noAudit":
+		table.NoAudit, err = toBool(value)
+	case "temporal":
+		table.Temporal, err = toBool(value)
+	default:
+		return fmt.Errorf("expected map, got %T", value)
+	}
+	return err
+}
+
+func (table *ConfigTable) HasField(name string) bool {
+	for _, f := range table.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldNames returns the names of the fields in order
+func (table *ConfigTable) FieldNames() []string {
+	ret := make([]string, len(table.Fields))
+	for i, f := range table.Fields {
+		ret[i] = f.Name
+	}
+	return ret
+}
+
+// PrimaryKey returns the first primary key field name
 func (table *ConfigTable) PrimaryKey() string {
+	pks := table.PrimaryKeys()
+	if len(pks) == 0 {
+		return ""
+	}
+	return pks[0]
+}
+
+// PrimaryKeys returns the primary key field names ordered by their pk number
+func (table *ConfigTable) PrimaryKeys() []string {
+	fields := make([]ConfigField, 0)
 	for _, f := range table.Fields {
 		if f.PrimaryKey > 0 {
-			return f.Name
+			fields = append(fields, f)
 		}
 	}
-	return ""
+	sort.SliceStable(fields, func(a, b int) bool {
+		return fields[a].PrimaryKey < fields[b].PrimaryKey
+	})
+	ret := make([]string, len(fields))
+	for i, f := range fields {
+		ret[i] = f.Name
+	}
+	return ret
 }
 
 func (a *ConfigField) CompareDbFields(b *TableFieldInfo) error {
@@ -324,6 +414,12 @@ func (a *ConfigField) CompareDbFields(b *TableFieldInfo) error {
 }
 
 func (f *ConfigField) ColDef() (string, error) {
+	return f.colDef(true)
+}
+
+// colDef returns the column definition, with the PRIMARY KEY constraint only
+// when inlinePk is true (composite keys are defined as a table constraint)
+func (f *ConfigField) colDef(inlinePk bool) (string, error) {
 
 	// lf := f.applySpecialFields()
 
@@ -346,9 +442,13 @@ func (f *ConfigField) ColDef() (string, error) {
 		}
 		s += " DEFAULT " + v
 	}
-	if f.PrimaryKey > 0 {
+	if f.PrimaryKey > 0 && inlinePk {
 		s += " PRIMARY KEY"
 	}
+	if f.IsJSON() {
+		// json_valid(NULL) is 0 in some versions of SQLite
+		s += " CHECK (`" + f.Name + "` ISNULL OR json_valid(`" + f.Name + "`))"
+	}
 	return s, nil
 }
 
@@ -385,6 +485,21 @@ func (c *Config) GetTable(name string) *ConfigTable {
 	return nil
 }
 
+func (c *Config) GetFunction(name string) *ConfigFunction {
+	if c == nil {
+		return nil
+	}
+	for _, function := range c.Functions {
+		if function.Name == name {
+			return &function
+		}
+	}
+	return nil
+}
+
+// GetBackReferences returns the references to the table from the fields of other tables.
+// A reference is to a single key field, so a table with a composite primary key can
+// only be referenced by another unique field.
 func (c *Config) GetBackReferences(name string) []*BackReference {
 	ret := make([]*BackReference, 0)
 	if c != nil {
@@ -408,6 +523,47 @@ func (c *Config) GetBackReferences(name string) []*BackReference {
 	return ret
 }
 
+// DependencyOrder returns the tables ordered so that referenced tables come before the
+// tables referencing them, ignoring self references and reference cycles
+func (c *Config) DependencyOrder(names []string) []string {
+	deps := make(map[string][]string)
+	for _, t := range names {
+		if ct := c.GetTable(t); ct != nil {
+			for _, f := range ct.Fields {
+				if f.References == "" {
+					continue
+				}
+				if ref, err := NewReference(f.References); err == nil && ref.Table != t {
+					deps[t] = append(deps[t], ref.Table)
+				}
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(names))
+	done := make(map[string]bool)
+	var visit func(t string, visiting map[string]bool)
+	visit = func(t string, visiting map[string]bool) {
+		if done[t] || visiting[t] {
+			return // Already added, or a reference cycle
+		}
+		visiting[t] = true
+		for _, dep := range deps[t] {
+			visit(dep, visiting)
+		}
+		done[t] = true
+		for _, n := range names {
+			if n == t {
+				ret = append(ret, t)
+			}
+		}
+	}
+	for _, t := range names {
+		visit(t, make(map[string]bool))
+	}
+	return ret
+}
+
 type YConfig struct {
 	Tables   map[string]map[string]ConfigField `yaml:"tables"`
 	Triggers map[string]ConfigTrigger          `yaml:"triggers"`
@@ -421,6 +577,7 @@ func NewConfigFromYaml(b []byte) (*Config, error) {
 		Triggers  map[string]yaml.MapSlice
 		Functions map[string]yaml.MapSlice
 		Views     map[string]string
+		Webhooks  map[string]ConfigWebhook
 	}
 	err := yaml.Unmarshal(b, &c)
 	if err != nil {
@@ -433,12 +590,28 @@ func NewConfigFromYaml(b []byte) (*Config, error) {
 		t := ConfigTable{
 			Name: tableName,
 		}
+		var seed interface{}
 		for _, x := range fields {
 			name, ok := x.Key.(string)
 			if !ok {
 				return nil, fmt.Errorf("%s: field name is not a string! It is a %T (%v)", tableName, x.Key, x.Key)
 			}
 
+			// Seed rows are set once the fields are known
+			if name == "seed" {
+				seed = x.Value
+				continue
+			}
+
+			// Fields are maps (or empty), anything else is a table option
+			if _, isMap := x.Value.(yaml.MapSlice); x.Value != nil && !isMap {
+				err = t.setOption(name, x.Value)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", tableName, name, err)
+				}
+				continue
+			}
+
 			f := newConfigFieldWithDefaults(name)
 
 			if x.Value != nil {
@@ -491,6 +664,11 @@ func NewConfigFromYaml(b []byte) (*Config, error) {
 						f.PrimaryKey = int(i)
 					case "unique":
 						f.Unique = tf
+					case "generate":
+						if _, ok := Generators[s]; !ok {
+							return nil, fmt.Errorf("%s.%s: unknown generator '%s'", tableName, f.Name, s)
+						}
+						f.Generate = s
 					case "indexed":
 						f.Indexed = tf
 					case "label":
@@ -501,6 +679,8 @@ func NewConfigFromYaml(b []byte) (*Config, error) {
 						f.ReadOnly = tf
 					case "hint":
 						f.Hint = s
+					case "noaudit":
+						f.NoAudit = tf
 					case "control":
 						f.Control = s
 					case "min":
@@ -521,9 +701,58 @@ func NewConfigFromYaml(b []byte) (*Config, error) {
 
 			t.Fields = append(t.Fields, f)
 		}
+		if seed != nil {
+			err = t.setSeed(seed)
+			if err != nil {
+				return nil, fmt.Errorf("%s.seed: %w", tableName, err)
+			}
+		}
 		cfg.Tables = append(cfg.Tables, t)
 	}
 
+	// A reference must identify a single row, which part of a composite key does not
+	for _, t := range cfg.Tables {
+		for _, f := range t.Fields {
+			if f.References == "" {
+				continue
+			}
+			ref, err := NewReference(f.References)
+			if err != nil {
+				continue
+			}
+			rt := cfg.GetTable(ref.Table)
+			if rt == nil || len(rt.PrimaryKeys()) < 2 {
+				continue
+			}
+			for _, rf := range rt.Fields {
+				if rf.Name == ref.KeyField && rf.PrimaryKey > 0 && !rf.Unique {
+					return nil, fmt.Errorf("%s.%s: references part of the composite primary key of %s", t.Name, f.Name, rt.Name)
+				}
+			}
+		}
+	}
+
+	// Rows referencing a soft deleted row are soft deleted with it, and the history
+	// of referencing rows is kept so asOf queries include their xxx_RefTable rows, so
+	// the referencing tables must have the same options
+	for _, t := range cfg.Tables {
+		for _, ref := range cfg.GetBackReferences(t.Name) {
+			st := cfg.GetTable(ref.SourceTable)
+			if t.SoftDelete && !st.SoftDelete {
+				return nil, fmt.Errorf("%s: must be softDelete as it references %s", st.Name, t.Name)
+			}
+			if t.Temporal && !st.Temporal {
+				return nil, fmt.Errorf("%s: must be temporal as it references %s", st.Name, t.Name)
+			}
+		}
+	}
+
+	for i := range cfg.Tables {
+		if cfg.Tables[i].SoftDelete && !cfg.Tables[i].HasField(DeletedAtField) {
+			cfg.Tables[i].Fields = append(cfg.Tables[i].Fields, newConfigFieldWithDefaults(DeletedAtField))
+		}
+	}
+
 	for triggerName, fields := range c.Triggers {
 		trigger := ConfigTrigger{
 			Name: triggerName,
@@ -643,19 +872,48 @@ func NewConfigFromYaml(b []byte) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	// WEBHOOKS
+	for webhookName, webhook := range c.Webhooks {
+		webhook.Name = webhookName
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %s: missing url", webhookName)
+		}
+		for _, e := range webhook.Events {
+			switch e {
+			case ChangeInsert, ChangeUpdate, ChangeDelete, ChangeFunction:
+			default:
+				return nil, fmt.Errorf("webhook %s: unknown event '%s'", webhookName, e)
+			}
+		}
+		for _, t := range webhook.Tables {
+			if cfg.GetTable(t) == nil {
+				return nil, fmt.Errorf("webhook %s: unknown table '%s'", webhookName, t)
+			}
+		}
+		for _, f := range webhook.Functions {
+			if cfg.GetFunction(f) == nil {
+				return nil, fmt.Errorf("webhook %s: unknown function '%s'", webhookName, f)
+			}
+		}
+		cfg.Webhooks = append(cfg.Webhooks, webhook)
+	}
 
 	sort.Slice(cfg.Tables, func(a, b int) bool {
 		return cfg.Tables[a].Name < cfg.Tables[b].Name
 	})
 
 	sort.Slice(cfg.Triggers, func(a, b int) bool {
-		return cfg.Triggers[a].Name < cfg.Tables[b].Name
+		return cfg.Triggers[a].Name < cfg.Triggers[b].Name
 	})
 
 	sort.Slice(cfg.Views, func(a, b int) bool {
 		return cfg.Views[a].Name < cfg.Views[b].Name
 	})
 
+	sort.Slice(cfg.Webhooks, func(a, b int) bool {
+		return cfg.Webhooks[a].Name < cfg.Webhooks[b].Name
+	})
+
 	// println("=========================================================")
 	// println(string(b))
 	// println("---------------------------------------------------------")
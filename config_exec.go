@@ -26,7 +26,7 @@ type GdbConfigRow struct {
 	ID        int       `db:"id"`
 	CreatedAt time.Time `db:"createdAt"`
 	Config    []byte    `db:"config"`
-	Hash      []byte    `sb:"hash`
+	Hash      []byte    `db:"hash"`
 }
 
 type ConfigOptions struct {
@@ -60,6 +60,14 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 		return
 	}
 
+	if len(c.Webhooks) > 0 {
+		_, err = d.DB.Exec(WebhookQueueCreateSql)
+		if err != nil {
+			err = fmt.Errorf("error creating webhook queue table: %w", err)
+			return
+		}
+	}
+
 	if opts == nil {
 		opts = &ConfigOptions{
 			RetainUnmanaged: true,
@@ -128,6 +136,9 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 		}
 		err = tx.Commit()
 		debugf("FINISHED: err: %v", err)
+		if err == nil && len(c.Webhooks) > 0 {
+			d.startWebhookWorker()
+		}
 	}()
 
 	// Remove tables
@@ -290,6 +301,37 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 				return
 			}
 		}
+
+		// ===================== Handle TEMPORAL tables =========================
+		if table.Temporal {
+			var change string
+			change, err = applyHistoryTableWithTx(tx, &table, slog)
+			if err != nil {
+				err = fmt.Errorf("error creating history table for '%s': %w", table.Name, err)
+				return
+			}
+			if change != "" {
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	if d.changeLog {
+		var change string
+		change, err = applyChangesTableWithTx(tx, c, slog)
+		if err != nil {
+			err = fmt.Errorf("error creating change log: %w", err)
+			return
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+
+	// Triggers include those keeping the history of temporal tables and the change log
+	triggers := append(c.historyTriggers(), c.Triggers...)
+	if d.changeLog {
+		triggers = append(triggers, c.changeTriggers()...)
 	}
 
 	if deletedSchemas {
@@ -308,7 +350,7 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 	for _, sch := range associatedSchemes {
 		if sch.Type == "trigger" {
 			del := true
-			for _, trigger := range c.Triggers {
+			for _, trigger := range triggers {
 				if trigger.Name == sch.Name {
 					del = false
 					break
@@ -326,9 +368,9 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 	}
 
 	// Triggers
-	if len(c.Triggers) > 0 {
+	if len(triggers) > 0 {
 	triggerLoop:
-		for _, trigger := range c.Triggers {
+		for _, trigger := range triggers {
 			// Find existing and compare if same skip drop/create
 			dropped := false
 			for _, sch := range associatedSchemes {
@@ -392,6 +434,11 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 		}
 	}
 
+	err = d.applySeedsWithTx(tx, c)
+	if err != nil {
+		return
+	}
+
 	b, err := yaml.Marshal(c)
 	if err != nil {
 		return
@@ -412,7 +459,7 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 		} else if bytes.Compare(b, oldYaml) == 0 {
 			debugf("No schema changes, old config matches new config")
 			// No need to save the config
-			d.config = c
+			d.setConfig(c)
 			return
 		}
 	}
@@ -440,7 +487,7 @@ func (d *Database) ApplyConfig(c *Config, opts *ConfigOptions) (err error) {
 		d.log.Printf("Database version is now %d:\n\t - "+strings.Join(changes, "\n\t - "), version)
 	}
 
-	d.config = c
+	d.setConfig(c)
 
 	return
 }
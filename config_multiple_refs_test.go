@@ -39,8 +39,7 @@ tables:
 	res, err := http.Get(tsRows.URL + "/table2")
 	assert.NoError(t, err)
 	assert.NotNil(t, res)
-	b, err := io.ReadAll(res.Body)
-	b = b
+	_, err = io.ReadAll(res.Body)
 	res.Body.Close()
 	assert.NoError(t, err)
 	// @TODO THIS IS CURRENTLY FAILING
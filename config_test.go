@@ -324,6 +324,34 @@ func TestConfigGetTable(t *testing.T) {
 	assert.Nil(t, c.GetTable("dummy"))
 }
 
+func TestConfigSortTriggers(t *testing.T) {
+	// More triggers than tables
+	c, err := NewConfigFromYaml([]byte(`
+tables:
+  table1:
+    id:
+triggers:
+  c:
+    table: table1
+    event: after insert
+    statement: SELECT 1
+  a:
+    table: table1
+    event: after update
+    statement: SELECT 1
+  b:
+    table: table1
+    event: after delete
+    statement: SELECT 1
+`))
+	assert.NoError(t, err)
+	names := []string{}
+	for _, trigger := range c.Triggers {
+		names = append(names, trigger.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+}
+
 func TestRemoveQuotes(t *testing.T) {
 	assert.Equal(t, removeQuotesIfString("'ABC'"), "ABC")
 	assert.Equal(t, removeQuotesIfString(`"ABC"`), "ABC")
@@ -0,0 +1,294 @@
+package sqliteapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CSVImportOptions controls how ImportCSV maps and writes the rows
+type CSVImportOptions struct {
+	Mapping      map[string]string // Header to field name, other headers must be field names
+	Upsert       []string          // Update the row with the same values in these fields, if one exists
+	DryRun       bool              // Validate and report, but write nothing
+	AllOrNothing bool              // Write nothing if any row fails
+	User         User
+}
+
+// CSVImportError is the error importing the row on the given line, line 1 being the header
+type CSVImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// CSVImportReport is the result of ImportCSV. When DryRun is set, or AllOrNothing is
+// set and there are errors, the counts are of the rows that would have been written.
+type CSVImportReport struct {
+	Inserted int              `json:"inserted"`
+	Updated  int              `json:"updated"`
+	Errors   []CSVImportError `json:"errors"`
+	Written  bool             `json:"written"` // False if nothing was written
+}
+
+type csvImporter struct {
+	d      *Database
+	tx     *sqlx.Tx
+	table  string
+	opts   *CSVImportOptions
+	fields []string         // Field of each column, "" if it is a _RefLabel column
+	labels map[int]csvLabel // _RefLabel columns
+	cache  map[string]interface{}
+}
+
+// csvLabel is a _RefLabel column, setting the key field from the referenced row
+type csvLabel struct {
+	keyField string
+	ref      *Reference
+}
+
+// ImportCSV inserts (or upserts) the rows of the csv, which has a header row of field
+// names, or of the headers in the mapping. A xxx_RefLabel column is looked up in the
+// referenced table to set the key field xxx, when xxx is empty or not given. Empty
+// cells are not set. Each row is validated and runs the hooks, and the rows with
+// errors are skipped and reported by line.
+func (d *Database) ImportCSV(table string, r io.Reader, opts *CSVImportOptions) (*CSVImportReport, error) {
+	if opts == nil {
+		opts = &CSVImportOptions{}
+	}
+	ti := d.dbInfo.GetTableInfo(table)
+	if ti == nil || ti.IsView {
+		return nil, ErrUnknownTable
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing csv header")
+	} else if err != nil {
+		return nil, err
+	}
+
+	imp := &csvImporter{
+		d:      d,
+		table:  table,
+		opts:   opts,
+		fields: make([]string, len(header)),
+		labels: make(map[int]csvLabel),
+		cache:  make(map[string]interface{}),
+	}
+	err = imp.mapHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var done func()
+	imp.tx, done, err = d.beginWrite(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	defer imp.tx.Rollback()
+
+	report := &CSVImportReport{
+		Errors: make([]CSVImportError, 0),
+	}
+	after := make([]HookParams, 0)
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			report.Errors = append(report.Errors, CSVImportError{pe.StartLine, pe.Err.Error()})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) != len(header) {
+			report.Errors = append(report.Errors, CSVImportError{line, fmt.Sprintf("expected %d fields, got %d", len(header), len(record))})
+			continue
+		}
+
+		// Each row is rolled back to the savepoint if it fails
+		_, err = imp.tx.Exec("SAVEPOINT csv_row")
+		if err != nil {
+			return nil, err
+		}
+		hp, err := imp.row(record)
+		if err != nil {
+			imp.tx.Exec("ROLLBACK TO csv_row")
+			report.Errors = append(report.Errors, CSVImportError{line, d.humaniseSqlError(err)})
+		} else {
+			if hp.Action == HookAfterInsert {
+				report.Inserted++
+			} else {
+				report.Updated++
+			}
+			after = append(after, hp)
+		}
+		_, err = imp.tx.Exec("RELEASE csv_row")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DryRun || (opts.AllOrNothing && len(report.Errors) > 0) {
+		return report, nil
+	}
+
+	err = imp.tx.Commit()
+	done()
+	if err != nil {
+		return nil, err
+	}
+	report.Written = true
+
+	for _, hp := range after {
+		err = d.runAfterHooks(table, hp)
+		if err != nil {
+			d.log.Printf("error running after csv import hook: %s", err)
+		}
+	}
+	return report, nil
+}
+
+// mapHeader sets the field, or _RefLabel reference, of each column
+func (imp *csvImporter) mapHeader(header []string) error {
+	ct := imp.d.getConfig().GetTable(imp.table)
+	ti := imp.d.dbInfo.GetTableInfo(imp.table)
+
+	for i, h := range header {
+		name := strings.TrimSpace(h)
+		if m, ok := imp.opts.Mapping[name]; ok {
+			name = m
+		}
+		if ti.HasField(name) {
+			imp.fields[i] = name
+			continue
+		}
+
+		if strings.HasSuffix(name, RefLabelSuffix) && ct != nil {
+			keyField := strings.TrimSuffix(name, RefLabelSuffix)
+			for _, f := range ct.Fields {
+				if f.Name == keyField && f.References != "" {
+					ref, err := NewReference(f.References)
+					if err != nil {
+						return err
+					}
+					imp.labels[i] = csvLabel{keyField, ref}
+				}
+			}
+			if _, ok := imp.labels[i]; ok {
+				continue
+			}
+		}
+		return fmt.Errorf("unknown csv column '%s'", h)
+	}
+	return nil
+}
+
+// row writes the record, returning the params for the after hook
+func (imp *csvImporter) row(record []string) (HookParams, error) {
+	d := imp.d
+	data := make(map[string]interface{})
+	for i, f := range imp.fields {
+		if f != "" && record[i] != "" {
+			data[f] = imp.value(f, record[i])
+		}
+	}
+
+	for i, label := range imp.labels {
+		if record[i] == "" || data[label.keyField] != nil {
+			continue
+		}
+		key, err := imp.lookup(label.ref, record[i])
+		if err != nil {
+			return HookParams{}, fmt.Errorf("%s: %w", label.keyField, err)
+		}
+		data[label.keyField] = key
+	}
+
+	if len(imp.opts.Upsert) > 0 {
+		id, inserted, err := d.upsertMapWithTx(imp.tx, imp.table, data, imp.opts.Upsert, imp.opts.User)
+		if err != nil {
+			return HookParams{}, err
+		}
+		action := HookAfterUpdate
+		if inserted {
+			action = HookAfterInsert
+		}
+		return HookParams{imp.table, id, data, action, imp.tx, imp.opts.User}, nil
+	}
+
+	err := d.runHooks(imp.table, HookParams{imp.table, nil, data, HookBeforeInsert, imp.tx, imp.opts.User})
+	if err != nil {
+		return HookParams{}, err
+	}
+	id, err := d.insertMapWithTx(imp.tx, imp.table, data, imp.opts.User)
+	if err != nil {
+		return HookParams{}, err
+	}
+	return HookParams{imp.table, id, data, HookAfterInsert, imp.tx, imp.opts.User}, nil
+}
+
+// value converts the cell to the field's type, leaving it as is if it does not convert
+// so validation reports the error
+func (imp *csvImporter) value(field string, s string) interface{} {
+	ct := imp.d.getConfig().GetTable(imp.table)
+	if ct == nil {
+		return s
+	}
+	for _, f := range ct.Fields {
+		if f.Name != field {
+			continue
+		}
+		switch strings.ToLower(f.Type) {
+		case "integer":
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i
+			}
+		case "real", "number":
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				return n
+			}
+		case "boolean", "bool":
+			if b, err := toBool(s); err == nil {
+				return b
+			}
+		}
+	}
+	return s
+}
+
+// lookup returns the key of the referenced row with the label
+func (imp *csvImporter) lookup(ref *Reference, label string) (interface{}, error) {
+	cacheKey := ref.Table + "\x00" + ref.LabelField + "\x00" + label
+	if key, ok := imp.cache[cacheKey]; ok {
+		return key, nil
+	}
+
+	keys := []interface{}{}
+	err := imp.tx.Select(&keys, "SELECT `"+ref.KeyField+"` FROM `"+ref.Table+"` WHERE `"+ref.LabelField+"`=? LIMIT 2", label)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	switch len(keys) {
+	case 0:
+		return nil, fmt.Errorf("no %s with %s '%s'", ref.Table, ref.LabelField, label)
+	case 1:
+		imp.cache[cacheKey] = keys[0]
+		return keys[0], nil
+	}
+	return nil, fmt.Errorf("more than one %s with %s '%s'", ref.Table, ref.LabelField, label)
+}
@@ -0,0 +1,167 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportCSV(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+  invoice:
+    id:
+    code:
+      unique: true
+      min: 3
+    customerId:
+      type: integer
+      ref: customer.id/name
+    total:
+      type: real
+    paid:
+      type: boolean
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, name := range []string{"Fred", "Bert"} {
+		_, err = db.InsertMap("customer", map[string]interface{}{"name": name}, nil)
+		assert.NoError(t, err)
+	}
+
+	afterInserts := 0
+	db.AddHook("invoice", func(p HookParams) error {
+		if p.Action == HookAfterInsert {
+			afterInserts++
+		}
+		return nil
+	})
+
+	count := func() int {
+		var n int
+		assert.NoError(t, db.DB.Get(&n, "SELECT COUNT(*) FROM invoice"))
+		return n
+	}
+
+	const data = `Customer,code,total,paid
+Fred,A001,9.99,true
+Bert,A002,"1,000",false
+Nobody,A003,1,false
+Fred,A4,1,true
+`
+	mapping := map[string]string{"Customer": "customerId_RefLabel"}
+
+	// Dry run
+	report, err := db.ImportCSV("invoice", strings.NewReader(data), &CSVImportOptions{Mapping: mapping, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Inserted)
+	assert.False(t, report.Written)
+	assert.Equal(t, 0, count())
+
+	// All or nothing
+	report, err = db.ImportCSV("invoice", strings.NewReader(data), &CSVImportOptions{Mapping: mapping, AllOrNothing: true})
+	assert.NoError(t, err)
+	assert.False(t, report.Written)
+	assert.Len(t, report.Errors, 3)
+	assert.Equal(t, 0, count())
+	assert.Equal(t, 0, afterInserts)
+
+	// Good rows only
+	report, err = db.ImportCSV("invoice", strings.NewReader(data), &CSVImportOptions{Mapping: mapping})
+	assert.NoError(t, err)
+	assert.True(t, report.Written)
+	assert.Equal(t, 1, report.Inserted)
+	assert.Equal(t, []CSVImportError{
+		{3, "total: expected a number"},
+		{4, "customerId: no customer with name 'Nobody'"},
+		{5, "code: too short, must be at least 3 chars"},
+	}, report.Errors)
+	assert.Equal(t, 1, count())
+	assert.Equal(t, 1, afterInserts)
+
+	var invoice struct {
+		CustomerID int64 `db:"customerId"`
+		Total      float64
+		Paid       bool
+	}
+	assert.NoError(t, db.DB.Get(&invoice, "SELECT customerId, total, paid FROM invoice WHERE code='A001'"))
+	assert.Equal(t, int64(1), invoice.CustomerID)
+	assert.Equal(t, 9.99, invoice.Total)
+	assert.True(t, invoice.Paid)
+
+	// Upsert, with the key field taking priority over the label
+	report, err = db.ImportCSV("invoice", strings.NewReader(`code,customerId,customerId_RefLabel,total
+A001,2,Fred,5
+A002,,Bert,6
+`), &CSVImportOptions{Upsert: []string{"code"}})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 1, report.Inserted)
+	assert.NoError(t, db.DB.Get(&invoice, "SELECT customerId, total, paid FROM invoice WHERE code='A001'"))
+	assert.Equal(t, int64(2), invoice.CustomerID)
+	assert.Equal(t, 5.0, invoice.Total)
+
+	_, err = db.ImportCSV("invoice", strings.NewReader("code,colour\n"), nil)
+	assert.EqualError(t, err, "unknown csv column 'colour'")
+
+	// Malformed rows are reported, including when the first field is malformed
+	report, err = db.ImportCSV("invoice", strings.NewReader("code,total\n\"A\"5,1\nA005,2\nA006,\"3\n"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Inserted)
+	if assert.Len(t, report.Errors, 2) {
+		assert.Equal(t, 2, report.Errors[0].Line)
+		assert.Contains(t, report.Errors[0].Error, "extraneous or missing \" in quoted-field")
+		assert.Equal(t, 4, report.Errors[1].Line)
+	}
+}
+
+func TestHandlePostCSV(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+      min: 2
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	post := func(query string, body string) (int, CSVImportReport) {
+		res, err := http.Post(ts.URL+"/customer"+query, "text/csv; charset=utf-8", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		var report CSVImportReport
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+		return res.StatusCode, report
+	}
+
+	status, report := post("?map=Name:name", "Name\nFred\nBert\n")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2, report.Inserted)
+	assert.True(t, report.Written)
+
+	status, report = post("?allOrNothing", "name\nAlf\nX\n")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, []CSVImportError{{3, "name: too short, must be at least 2 chars"}}, report.Errors)
+	assert.False(t, report.Written)
+
+	var n int
+	assert.NoError(t, db.DB.Get(&n, "SELECT COUNT(*) FROM customer"))
+	assert.Equal(t, 2, n)
+}
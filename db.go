@@ -1,8 +1,11 @@
 package sqliteapi
 
 import (
+	"context"
+	"database/sql"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"regexp"
 	"sync"
 	"time"
@@ -17,13 +20,22 @@ const (
 )
 
 type Database struct {
-	DB       *sqlx.DB
-	log      SimpleLogger
-	debugLog SimpleLogger
-	hooks    []Hook
-	dbInfo   TableInfos
-	config   *Config
-	timeout  time.Duration
+	DB          *sqlx.DB
+	log         SimpleLogger
+	debugLog    SimpleLogger
+	hooks       []Hook
+	dbInfo      TableInfos
+	config      *Config
+	configLock  sync.RWMutex // Guards config, which is replaced by ApplyConfig and Restore
+	timeout     time.Duration
+	audit       bool
+	changeLog   bool
+	subscribers subscribers
+	webhooks    webhookWorker
+	syncing     sync.Mutex // Prevents overlapping syncs
+	backups     *backupScheduler
+	requestUser func(r *http.Request) User
+	writes      sync.RWMutex // Read locked by write transactions, write locked by Restore
 	sync.Mutex
 }
 
@@ -57,7 +69,44 @@ func NewDatabase(file string, opts ...Option) (*Database, error) {
 	return d, nil
 }
 
+func (d *Database) getConfig() *Config {
+	d.configLock.RLock()
+	defer d.configLock.RUnlock()
+	return d.config
+}
+
+func (d *Database) setConfig(c *Config) {
+	d.configLock.Lock()
+	d.config = c
+	d.configLock.Unlock()
+}
+
+// beginWrite begins a write transaction, waiting while the database is being restored.
+// done must be called once the transaction has been committed or rolled back, it may
+// be called more than once.
+func (d *Database) beginWrite(ctx context.Context) (tx *sqlx.Tx, done func(), err error) {
+	d.writes.RLock()
+	tx, err = d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		d.writes.RUnlock()
+		return nil, nil, err
+	}
+	var once sync.Once
+	return tx, func() { once.Do(d.writes.RUnlock) }, nil
+}
+
+// execWrite executes a single write statement outside of a transaction, waiting while
+// the database is being restored
+func (d *Database) execWrite(query string, args ...interface{}) (sql.Result, error) {
+	d.writes.RLock()
+	defer d.writes.RUnlock()
+	return d.DB.Exec(query, args...)
+}
+
 func (d *Database) Close() {
+	d.stopBackupSchedule()
+	d.stopWebhookWorker()
+	d.closeSubscribers()
 	d.DB.Close()
 }
 
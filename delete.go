@@ -3,11 +3,18 @@ package sqliteapi
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// Delete deletes the row and the rows referencing it. Rows of softDelete tables
+// have their deletedAt field set instead, use Purge to permanently delete them.
 func (d *Database) Delete(table string, key interface{}, user User) (err error) {
+	return d.delete(table, key, user, false)
+}
+
+func (d *Database) delete(table string, key interface{}, user User, purge bool) (err error) {
 	tableInfo := d.dbInfo.GetTableInfo(table)
 	if tableInfo == nil {
 		return ErrUnknownTable
@@ -15,80 +22,148 @@ func (d *Database) Delete(table string, key interface{}, user User) (err error)
 
 	defer func() {
 		if err != nil {
-			d.log.Printf("%s: error deleting row where %s = '%v': %v", table, tableInfo.GetPrimaryKey().Field, key, err)
+			d.log.Printf("%s: error deleting row where %s = '%v': %v", table, strings.Join(tableInfo.GetPrimaryKeyFields(), ","), key, err)
 		}
 	}()
 
 	var tx *sqlx.Tx
-	ctx, _ := context.WithTimeout(context.Background(), d.timeout)
-	tx, err = d.DB.BeginTxx(ctx, nil)
+	var done func()
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err = d.beginWrite(ctx)
 	if err != nil {
 		return err
 	}
+	defer done()
 
 	var data map[string]interface{}
-	data, err = d.GetMap(table, key, false)
+	data, err = d.deleteWithTx(tx, table, key, user, purge)
 	if err != nil {
 		tx.Rollback()
 		return
 	}
 
-	err = d.runHooks(table, HookParams{table, key, data, HookBeforeDelete, tx, user})
+	err = d.runHooks(table, HookParams{table, key, data, HookAfterDelete, tx, user})
+	if err != nil {
+		d.log.Printf("error running after delete hook: %s", err)
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		d.log.Printf("error running before delete hook: %s", err)
 		tx.Rollback()
 		return
 	}
+	d.publishHook(HookParams{table, key, data, HookAfterDelete, tx, user})
+	d.log.Printf("%s: Deleted row where %s = '%v'", table, strings.Join(tableInfo.GetPrimaryKeyFields(), ","), key)
 
-	// @TODO replace this in the FOREIGN KEY??
-	for _, ref := range d.config.GetBackReferences(table) {
-		skey, ok := data[ref.KeyField]
-		if ok {
-			sql := "DELETE FROM `" + ref.SourceTable + "`"
-			sql += " WHERE " + ref.SourceField + "=?"
-			d.debugLog.Printf("SQL: %s\nArgs: %v\n", sql, skey)
-			_, err = tx.Exec(sql, skey)
-			if err != nil {
-				tx.Rollback()
-				return
-			}
-		}
+	return nil
+}
+
+// deleteWithTx runs the before delete hook and deletes the row (and any back
+// referenced rows) using the given transaction, returning the deleted row. Rows
+// of softDelete tables are only permanently deleted when purge is true.
+func (d *Database) deleteWithTx(tx *sqlx.Tx, table string, key interface{}, user User, purge bool) (data map[string]interface{}, err error) {
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return nil, ErrUnknownTable
 	}
 
-	q := "DELETE FROM `" + table + "`"
-	q += " WHERE " + tableInfo.GetPrimaryKey().Field + "=?"
-	d.debugLog.Printf("SQL: %s\nArgs: %v\n", q, key)
-	var res sql.Result
-	res, err = tx.Exec(q, key)
+	opts := GetOptions{}
+	if purge {
+		opts.Deleted = WithDeleted
+	}
+	data, err = d.getMapWithTx(tx, table, key, opts)
 	if err != nil {
-		tx.Rollback()
 		return
 	}
+	if d.isProtectedSeedRow(table, data) {
+		return nil, ErrSeedProtected
+	}
 
-	var v int64
-	v, err = res.RowsAffected()
+	err = d.runHooks(table, HookParams{table, key, data, HookBeforeDelete, tx, user})
 	if err != nil {
-		tx.Rollback()
+		d.log.Printf("error running before delete hook: %s", err)
 		return
 	}
-	if v == 0 {
-		tx.Rollback()
-		return ErrUnknownKey
+
+	if !purge && d.isSoftDelete(table) {
+		err = d.softDeleteWithTx(tx, table, key, data, user)
+		return
 	}
 
-	err = d.runHooks(table, HookParams{table, key, data, HookAfterDelete, tx, user})
+	// @TODO replace this in the FOREIGN KEY??
+	err = d.deleteRefsWithTx(tx, table, data, user)
 	if err != nil {
-		d.log.Printf("error running after delete hook: %s", err)
-		tx.Rollback()
-		return err
+		return
 	}
 
-	err = tx.Commit()
+	var keyValues []interface{}
+	keyValues, err = tableInfo.KeyValues(key)
 	if err != nil {
-		tx.Rollback()
 		return
 	}
-	d.log.Printf("%s: Deleted row where %s = '%v'", table, tableInfo.GetPrimaryKey().Field, key)
 
+	q := "DELETE FROM `" + table + "`"
+	q += " WHERE " + tableInfo.KeyWhere()
+	d.debugLog.Printf("SQL: %s\nArgs: %v\n", q, keyValues)
+	err = d.auditWithTx(tx, table, AuditDelete, tableInfo.KeyWhere(), keyValues, user, func() error {
+		var res sql.Result
+		res, err := tx.Exec(q, keyValues...)
+		if err != nil {
+			return err
+		}
+
+		v, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			return ErrUnknownKey
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// deleteRefsWithTx deletes the rows referencing the row, and the rows referencing them.
+// The foreign keys are checked on commit, as rows are deleted before their references.
+func (d *Database) deleteRefsWithTx(tx *sqlx.Tx, table string, data map[string]interface{}, user User) error {
+	_, err := tx.Exec("PRAGMA defer_foreign_keys=ON")
+	if err != nil {
+		return err
+	}
+	for _, ref := range d.getConfig().GetBackReferences(table) {
+		skey, ok := data[ref.KeyField]
+		if !ok {
+			continue
+		}
+		where := "`" + ref.SourceField + "`=?"
+		rows, err := d.selectRowsWithTx(tx, ref.SourceTable, where, []interface{}{skey})
+		if err != nil {
+			return err
+		}
+		sql := "DELETE FROM `" + ref.SourceTable + "`"
+		sql += " WHERE " + where
+		d.debugLog.Printf("SQL: %s\nArgs: %v\n", sql, skey)
+		err = d.auditWithTx(tx, ref.SourceTable, AuditDelete, where, []interface{}{skey}, user, func() error {
+			_, err := tx.Exec(sql, skey)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			err = d.deleteRefsWithTx(tx, ref.SourceTable, row, user)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
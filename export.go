@@ -0,0 +1,448 @@
+package sqliteapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// exportTimeFormat is how SQLite's date functions write times, as times are exported
+// as they are stored rather than as RFC 3339
+const exportTimeFormat = "2006-01-02 15:04:05.999"
+
+var ErrImportConfig = errors.New("import does not match the config")
+
+// ExportOptions selects what Export writes
+type ExportOptions struct {
+	Format string   // ExportJSON (default) or ExportNDJSON
+	Tables []string // Defaults to all tables in the config
+}
+
+// ImportOptions controls how Import reads and writes the rows
+type ImportOptions struct {
+	Format  string // ExportJSON (default) or ExportNDJSON
+	KeepIDs bool   // Insert rows with their exported integer ids instead of new ids
+	User    User
+}
+
+// ImportResult is the number of rows imported into each table, and the number given
+// a new id different to their exported id
+type ImportResult struct {
+	Rows     map[string]int `json:"rows"`
+	Remapped int            `json:"remapped"`
+}
+
+// exportHeader starts an export, being the first line of ndjson
+type exportHeader struct {
+	Version int      `json:"version"`
+	Config  string   `json:"config"` // Yaml, as stored in gdb_config
+	Tables  []string `json:"tables"` // In dependency order
+}
+
+// exportRow is a line of ndjson after the header
+type exportRow struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// Export writes the rows of the tables in the config, with referenced tables before
+// the tables referencing them, along with the config. The rows are read in a single
+// transaction, so are consistent.
+func (d *Database) Export(w io.Writer, opts *ExportOptions) error {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	if d.getConfig() == nil {
+		return errors.New("export: no config")
+	}
+
+	names := []string{}
+	if len(opts.Tables) > 0 {
+		for _, t := range opts.Tables {
+			if d.getConfig().GetTable(t) == nil {
+				return fmt.Errorf("%w: %s", ErrUnknownTable, t)
+			}
+			names = append(names, t)
+		}
+	} else {
+		for _, t := range d.getConfig().Tables {
+			names = append(names, t.Name)
+		}
+	}
+	sort.Strings(names)
+
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b, err := yaml.Marshal(d.getConfig())
+	if err != nil {
+		return err
+	}
+	header := exportHeader{
+		Config: string(b),
+		Tables: d.getConfig().DependencyOrder(names),
+	}
+	err = tx.Get(&header.Version, "PRAGMA user_version")
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	switch opts.Format {
+	case ExportNDJSON:
+		err = enc.Encode(header)
+		if err != nil {
+			return err
+		}
+		for _, t := range header.Tables {
+			err = exportRowsWithTx(tx, t, func(row []byte) error {
+				return enc.Encode(exportRow{t, row})
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+	case ExportJSON, "":
+		hb, err := json.Marshal(header)
+		if err != nil {
+			return err
+		}
+		// The header with a rows object of each table's rows added
+		bw.Write(hb[:len(hb)-1])
+		bw.WriteString(`,"rows":{`)
+		for i, t := range header.Tables {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			tb, _ := json.Marshal(t)
+			bw.Write(tb)
+			bw.WriteString(":[")
+			n := 0
+			err = exportRowsWithTx(tx, t, func(row []byte) error {
+				if n > 0 {
+					bw.WriteString(",")
+				}
+				n++
+				_, err := bw.Write(row)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			bw.WriteString("]")
+		}
+		bw.WriteString("}}\n")
+
+	default:
+		return fmt.Errorf("unknown export format '%s'", opts.Format)
+	}
+
+	return bw.Flush()
+}
+
+// exportRowsWithTx calls fn with each row of the table json encoded, including all fields
+func exportRowsWithTx(tx *sqlx.Tx, table string, fn func(row []byte) error) error {
+	rows, err := tx.Queryx("SELECT * FROM `" + table + "` ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			return err
+		}
+		for k, v := range row {
+			switch x := v.(type) {
+			case time.Time:
+				row[k] = x.UTC().Format(exportTimeFormat)
+			case []byte:
+				row[k] = string(x)
+			}
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		err = fn(b)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// importer inserts the rows of an import in a single transaction
+type importer struct {
+	d       *Database
+	tx      *sqlx.Tx
+	opts    *ImportOptions
+	ids     map[string]map[int64]int64 // Exported id to new id by table
+	pending []importRef
+	result  *ImportResult
+}
+
+// importRef is a reference to a row not yet imported, updated once all rows are
+type importRef struct {
+	table  string
+	rowid  int64
+	field  string
+	ref    string // Table
+	oldKey int64
+}
+
+// Import inserts the rows written by Export, after checking the exported config matches
+// the running config. Rows are given new ids (unless KeepIDs is set) with the references
+// to them updated to match. The import is all or nothing, and hooks are not run.
+func (d *Database) Import(r io.Reader, opts *ImportOptions) (*ImportResult, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	if d.getConfig() == nil {
+		return nil, errors.New("import: no config")
+	}
+
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	defer tx.Rollback()
+
+	// References are checked on commit, once the rows they reference are imported
+	_, err = tx.Exec("PRAGMA defer_foreign_keys=ON")
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importer{
+		d:    d,
+		tx:   tx,
+		opts: opts,
+		ids:  make(map[string]map[int64]int64),
+		result: &ImportResult{
+			Rows: make(map[string]int),
+		},
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	switch opts.Format {
+	case ExportNDJSON:
+		var header exportHeader
+		err = dec.Decode(&header)
+		if err != nil {
+			return nil, fmt.Errorf("import: header: %w", err)
+		}
+		err = imp.checkConfig(header)
+		if err != nil {
+			return nil, err
+		}
+		for line := 2; ; line++ {
+			var row exportRow
+			err = dec.Decode(&row)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("import: line %d: %w", line, err)
+			}
+			err = imp.insert(row.Table, row.Row)
+			if err != nil {
+				return nil, fmt.Errorf("import: line %d: %w", line, err)
+			}
+		}
+
+	case ExportJSON, "":
+		var export struct {
+			exportHeader
+			Rows map[string][]json.RawMessage `json:"rows"`
+		}
+		err = dec.Decode(&export)
+		if err != nil {
+			return nil, fmt.Errorf("import: %w", err)
+		}
+		err = imp.checkConfig(export.exportHeader)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range export.Tables {
+			for i, row := range export.Rows[t] {
+				err = imp.insert(t, row)
+				if err != nil {
+					return nil, fmt.Errorf("import: %s row %d: %w", t, i+1, err)
+				}
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown import format '%s'", opts.Format)
+	}
+
+	err = imp.updatePending()
+	if err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("import: %s", d.humaniseSqlError(err))
+	}
+	return imp.result, nil
+}
+
+// checkConfig checks the exported tables and their fields are in the running config,
+// with the same references
+func (imp *importer) checkConfig(header exportHeader) error {
+	c, err := decodeStoredConfig([]byte(header.Config))
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	for _, t := range header.Tables {
+		et := c.GetTable(t)
+		rt := imp.d.getConfig().GetTable(t)
+		if et == nil || rt == nil {
+			return fmt.Errorf("%w: table '%s'", ErrImportConfig, t)
+		}
+		for _, ef := range et.Fields {
+			found := false
+			for _, rf := range rt.Fields {
+				if rf.Name == ef.Name {
+					if rf.References != ef.References {
+						return fmt.Errorf("%w: %s.%s references '%s' not '%s'", ErrImportConfig, t, ef.Name, ef.References, rf.References)
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%w: field %s.%s", ErrImportConfig, t, ef.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (imp *importer) insert(table string, b []byte) error {
+	d := imp.d
+	ct := d.getConfig().GetTable(table)
+	ti := d.dbInfo.GetTableInfo(table)
+	if ct == nil || ti == nil || ti.IsView {
+		return fmt.Errorf("%w: table '%s'", ErrImportConfig, table)
+	}
+
+	row, err := decodeJsonRow(b)
+	if err != nil {
+		return err
+	}
+
+	fields := []string{}
+	values := []interface{}{}
+	refs := []importRef{}
+	for k := range row {
+		if !ti.HasField(k) {
+			return fmt.Errorf("%w: field %s.%s", ErrImportConfig, table, k)
+		}
+	}
+
+	pk := ""
+	var oldKey int64
+	if !imp.opts.KeepIDs {
+		pk = syncIntegerKey(d, table)
+		if pk != "" {
+			oldKey, _ = row[pk].(int64)
+			delete(row, pk)
+		}
+	}
+
+	for _, f := range ct.Fields {
+		v, ok := row[f.Name]
+		if !ok {
+			continue
+		}
+		if f.References != "" && !imp.opts.KeepIDs {
+			ref, err := NewReference(f.References)
+			old, isInt := v.(int64)
+			if err == nil && isInt && syncIntegerKey(d, ref.Table) == ref.KeyField {
+				if newKey, ok := imp.ids[ref.Table][old]; ok {
+					v = newKey
+				} else {
+					refs = append(refs, importRef{table, 0, f.Name, ref.Table, old})
+				}
+			}
+		}
+		fields = append(fields, f.Name)
+		values = append(values, v)
+	}
+
+	q := "INSERT INTO `" + table + "` DEFAULT VALUES"
+	if len(fields) > 0 {
+		q = "INSERT INTO `" + table + "` (`" + strings.Join(fields, "`,`") + "`) VALUES (?" +
+			strings.Repeat(",?", len(fields)-1) + ")"
+	}
+	res, err := imp.tx.Exec(q, values...)
+	if err != nil {
+		return errors.New(d.humaniseSqlError(err))
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	err = d.auditInsertWithTx(imp.tx, table, rowid, imp.opts.User)
+	if err != nil {
+		return err
+	}
+
+	if pk != "" {
+		if imp.ids[table] == nil {
+			imp.ids[table] = make(map[int64]int64)
+		}
+		imp.ids[table][oldKey] = rowid
+		if oldKey != rowid {
+			imp.result.Remapped++
+		}
+	}
+	for _, ref := range refs {
+		ref.rowid = rowid
+		imp.pending = append(imp.pending, ref)
+	}
+	imp.result.Rows[table]++
+	return nil
+}
+
+// updatePending updates the references to rows imported after the rows referencing
+// them, e.g. self references
+func (imp *importer) updatePending() error {
+	for _, ref := range imp.pending {
+		newKey, ok := imp.ids[ref.ref][ref.oldKey]
+		if !ok {
+			continue // Not imported, so must already exist
+		}
+		_, err := imp.tx.Exec("UPDATE `"+ref.table+"` SET `"+ref.field+"`=? WHERE rowid=?", newKey, ref.rowid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    id:
+    createdAt:
+    customerId:
+      type: integer
+      ref: customer.id/name
+    total:
+      type: real
+  customer:
+    id:
+    name:
+    referrerId:
+      type: integer
+      ref: customer.id/name
+`
+	dir := t.TempDir()
+	src, err := NewDatabase(filepath.Join(dir, "src.db"), YamlConfig([]byte(yaml)))
+	assert.NoError(t, err)
+	defer src.Close()
+
+	fred, err := src.InsertMap("customer", map[string]interface{}{"name": "Fred"}, nil)
+	assert.NoError(t, err)
+	_, err = src.InsertMap("customer", map[string]interface{}{"name": "Bert", "referrerId": fred}, nil)
+	assert.NoError(t, err)
+	_, err = src.InsertMap("invoice", map[string]interface{}{"customerId": fred, "total": 9.99}, nil)
+	assert.NoError(t, err)
+	// Referencing a later row
+	_, err = src.DB.Exec("UPDATE customer SET referrerId=2 WHERE id=1")
+	assert.NoError(t, err)
+
+	var createdAt string
+	assert.NoError(t, src.DB.Get(&createdAt, "SELECT CAST(createdAt AS TEXT) FROM invoice"))
+
+	for _, format := range []string{ExportJSON, ExportNDJSON} {
+		var buf bytes.Buffer
+		assert.NoError(t, src.Export(&buf, &ExportOptions{Format: format}))
+
+		if format == ExportNDJSON {
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			assert.Len(t, lines, 4)
+			var header exportHeader
+			assert.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+			assert.Equal(t, []string{"customer", "invoice"}, header.Tables)
+			assert.Equal(t, 1, header.Version)
+		} else {
+			assert.True(t, json.Valid(buf.Bytes()))
+		}
+
+		// Into a database with existing rows, so the ids change
+		dst, err := NewDatabase(filepath.Join(dir, format+".db"), YamlConfig([]byte(yaml)))
+		assert.NoError(t, err)
+		defer dst.Close()
+		for _, name := range []string{"Existing 1", "Existing 2", "Existing 3"} {
+			_, err = dst.InsertMap("customer", map[string]interface{}{"name": name}, nil)
+			assert.NoError(t, err)
+		}
+
+		res, err := dst.Import(&buf, &ImportOptions{Format: format})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"customer": 2, "invoice": 1}, res.Rows, format)
+		assert.Equal(t, 2, res.Remapped, format)
+
+		var rows []struct {
+			Name     string
+			Referrer string
+		}
+		assert.NoError(t, dst.DB.Select(&rows, `SELECT c.name AS name, r.name AS referrer FROM customer c
+			JOIN customer r ON r.id=c.referrerId ORDER BY c.id`))
+		assert.Equal(t, "Fred", rows[0].Name)
+		assert.Equal(t, "Bert", rows[0].Referrer)
+		assert.Equal(t, "Bert", rows[1].Name)
+		assert.Equal(t, "Fred", rows[1].Referrer)
+
+		var invoice struct {
+			Name      string
+			Total     float64
+			CreatedAt string
+		}
+		assert.NoError(t, dst.DB.Get(&invoice, `SELECT c.name AS name, i.total AS total, CAST(i.createdAt AS TEXT) AS createdat
+			FROM invoice i JOIN customer c ON c.id=i.customerId`))
+		assert.Equal(t, "Fred", invoice.Name)
+		assert.Equal(t, 9.99, invoice.Total)
+		assert.Equal(t, createdAt, invoice.CreatedAt)
+	}
+
+	// Exported ids are kept
+	var buf bytes.Buffer
+	assert.NoError(t, src.Export(&buf, &ExportOptions{Tables: []string{"customer"}}))
+	dst, err := NewDatabase(filepath.Join(dir, "keep.db"), YamlConfig([]byte(yaml)))
+	assert.NoError(t, err)
+	defer dst.Close()
+	res, err := dst.Import(&buf, &ImportOptions{KeepIDs: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Remapped)
+	var name string
+	assert.NoError(t, dst.DB.Get(&name, "SELECT name FROM customer WHERE id=2"))
+	assert.Equal(t, "Bert", name)
+
+	// A config without the exported fields is rejected, importing nothing
+	assert.NoError(t, src.Export(&buf, nil))
+	other, err := NewDatabase(filepath.Join(dir, "other.db"), YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+  invoice:
+    id:
+    customerId:
+      type: integer
+      ref: customer.id/name
+`)))
+	assert.NoError(t, err)
+	defer other.Close()
+	_, err = other.Import(&buf, nil)
+	assert.ErrorIs(t, err, ErrImportConfig)
+	var n int
+	assert.NoError(t, other.DB.Get(&n, "SELECT COUNT(*) FROM customer"))
+	assert.Equal(t, 0, n)
+}
@@ -20,17 +20,30 @@ const (
 )
 
 func (d *Database) FieldValidation(table string, field string, value interface{}) error {
-	if d.config == nil {
+	if d.getConfig() == nil {
 		return nil
 	}
 
-	t := d.config.GetTable(table)
+	t := d.getConfig().GetTable(table)
 	if t == nil {
 		return nil
 	}
 
 	for _, tf := range t.Fields {
 		if tf.Name == field {
+			if tf.IsJSON() {
+				if value == nil || value == "" {
+					if tf.NotNull {
+						return fmt.Errorf("%s: missing value", field)
+					}
+					return nil
+				}
+				_, err := jsonText(value)
+				if err != nil {
+					return fmt.Errorf("%s: %w", field, err)
+				}
+				return nil
+			}
 		checkagain:
 			switch v := value.(type) {
 			case int:
@@ -104,11 +117,11 @@ func (d *Database) FieldValidation(table string, field string, value interface{}
 }
 
 func (d *Database) IsFieldWritable(table string, field string) bool {
-	if d.config == nil {
+	if d.getConfig() == nil {
 		return true
 	}
 
-	t := d.config.GetTable(table)
+	t := d.getConfig().GetTable(table)
 	if t == nil {
 		return true
 	}
@@ -122,11 +135,11 @@ func (d *Database) IsFieldWritable(table string, field string) bool {
 }
 
 func (d *Database) IsFieldReadable(table string, field string) bool {
-	if d.config == nil {
+	if d.getConfig() == nil {
 		return true
 	}
 
-	t := d.config.GetTable(table)
+	t := d.getConfig().GetTable(table)
 	if t == nil {
 		return true
 	}
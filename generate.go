@@ -0,0 +1,129 @@
+package sqliteapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	GenerateUUID   = "uuid"
+	GenerateULID   = "ulid"
+	GenerateNanoID = "nanoid"
+)
+
+// Generators maps the ConfigField generate values to functions returning a new
+// unique value. Additional generators can be added.
+var Generators = map[string]func() (string, error){
+	GenerateUUID:   NewUUID,
+	GenerateULID:   NewULID,
+	GenerateNanoID: NewNanoID,
+}
+
+// NewUUID returns a random (version 4) UUID e.g. 7d444840-9dc0-41a8-8b2c-94bb6a4f3c9e
+func NewUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 10
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID, a 48 bit millisecond timestamp followed by 80 random bits
+// encoded as 26 chars, so they sort by creation time e.g. 01ARZ3NDEKTSV4RRFFQ69G5FAV
+func NewULID() (string, error) {
+	b := make([]byte, 16)
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*uint(i)))
+	}
+	_, err := rand.Read(b[6:])
+	if err != nil {
+		return "", err
+	}
+
+	// 128 bits encoded 5 bits at a time, with 2 leading zero bits
+	s := make([]byte, 26)
+	var acc uint32
+	bits := uint(2)
+	n := 0
+	for _, x := range b {
+		acc = acc<<8 | uint32(x)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			s[n] = crockfordBase32[(acc>>bits)&0x1f]
+			n++
+		}
+	}
+	return string(s), nil
+}
+
+const nanoIDAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// NewNanoID returns a 21 char url safe random id e.g. V1StGXR8_Z5jdHi6B-myT
+func NewNanoID() (string, error) {
+	b := make([]byte, 21)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = nanoIDAlphabet[b[i]&63]
+	}
+	return string(b), nil
+}
+
+// generateFields sets a new value for each field with a generator that is missing or
+// empty in data, returning the names of the generated fields
+func (d *Database) generateFields(table string, data map[string]interface{}) ([]string, error) {
+	ret := make([]string, 0)
+	t := d.getConfig().GetTable(table)
+	if t == nil {
+		return ret, nil
+	}
+	for _, f := range t.Fields {
+		if f.Generate == "" {
+			continue
+		}
+		if v, ok := data[f.Name]; ok && v != nil && v != "" {
+			ret = append(ret, f.Name) // Keep the given value e.g. created offline
+			continue
+		}
+		fn, ok := Generators[f.Generate]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown generator '%s'", f.Name, f.Generate)
+		}
+		v, err := fn()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		data[f.Name] = v
+		ret = append(ret, f.Name)
+	}
+	return ret, nil
+}
+
+// insertedKey returns the key for a newly inserted row, which is the generated
+// primary key if there is one, otherwise the given rowid
+func (d *Database) insertedKey(table string, data map[string]interface{}, id int64) interface{} {
+	t := d.getConfig().GetTable(table)
+	if t == nil {
+		return id
+	}
+	for _, f := range t.Fields {
+		if f.PrimaryKey > 0 && f.Generate != "" {
+			if ti := d.dbInfo.GetTableInfo(table); ti != nil {
+				return ti.GetKey(data)
+			}
+		}
+	}
+	return id
+}
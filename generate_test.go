@@ -0,0 +1,107 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerators(t *testing.T) {
+	u, err := NewUUID()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), u)
+
+	u1, err := NewULID()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`), u1)
+
+	n, err := NewNanoID()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[\w-]{21}$`), n)
+
+	_, err = NewConfigFromYaml([]byte(`
+tables:
+  t:
+    id:
+      type: text
+      generate: serial
+`))
+	assert.Error(t, err)
+}
+
+func TestGeneratedPrimaryKey(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    id:
+      type: text
+      generate: uuid
+    customer:
+  invoiceItem:
+    id:
+      type: text
+      generate: ulid
+    invoiceId:
+      ref: invoice.id/customer
+    item:
+    tag:
+      generate: nanoid
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	inv := map[string]interface{}{
+		"customer": "Fred Blogs",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item A"},
+			{"item": "Item B"},
+		},
+	}
+	_, err = db.InsertMap("invoice", inv, nil)
+	assert.NoError(t, err)
+
+	key, ok := inv["id"].(string)
+	assert.True(t, ok)
+	assert.Len(t, key, 36)
+
+	row, err := db.GetMap("invoice", key, true)
+	assert.NoError(t, err)
+	items := row["invoiceItem_RefTable"].([]map[string]interface{})
+	assert.Len(t, items, 2)
+	for _, item := range items {
+		assert.Equal(t, key, item["invoiceId"])
+		assert.Len(t, item["id"], 26)
+		assert.Len(t, item["tag"], 21)
+	}
+
+	// A given key is kept e.g. a row created offline
+	_, err = db.InsertMap("invoice", map[string]interface{}{"id": "offline-1", "customer": "Offline"}, nil)
+	assert.NoError(t, err)
+	_, err = db.GetMap("invoice", "offline-1", false)
+	assert.NoError(t, err)
+
+	// POST returns the generated key
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+	res, err := http.Post(ts.URL+"/invoice", "application/json", bytes.NewBufferString(`{"customer":"ACME"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err)
+	assert.Len(t, b, 36)
+
+	row, err = db.GetMap("invoice", string(b), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACME", row["customer"])
+}
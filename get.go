@@ -3,15 +3,38 @@ package sqliteapi
 import (
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
+// GetOptions control what GetMapWithOptions returns
+type GetOptions struct {
+	// WithRefTables adds the rows of tables referencing this row as xxx_RefTable fields
+	WithRefTables bool
+
+	// Deleted controls if soft deleted rows are returned
+	Deleted DeletedMode
+
+	// AsOf if set returns the row as it was at the time, the table must be temporal
+	AsOf time.Time
+}
+
 func (d *Database) GetMap(table string, pk interface{}, withRefTables bool) (map[string]interface{}, error) {
+	return d.GetMapWithOptions(table, pk, GetOptions{WithRefTables: withRefTables})
+}
+
+func (d *Database) GetMapWithOptions(table string, pk interface{}, opts GetOptions) (map[string]interface{}, error) {
 	tx, err := d.DB.Beginx()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback() // This is a query so we always rollback
 
+	return d.getMapWithTx(tx, table, pk, opts)
+}
+
+func (d *Database) getMapWithTx(tx *sqlx.Tx, table string, pk interface{}, opts GetOptions) (map[string]interface{}, error) {
 	sb := NewSelectBuilder(table, []string{})
 
 	tableInfo := d.dbInfo.GetTableInfo(table)
@@ -19,40 +42,72 @@ func (d *Database) GetMap(table string, pk interface{}, withRefTables bool) (map
 		return nil, ErrUnknownTable
 	}
 
-	sb.Where = []string{tableInfo.GetPrimaryKey().String() + "=?"}
+	keyValues, err := tableInfo.KeyValues(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.Where = []string{tableInfo.KeyWhere()}
 
 	d.AddRefLabels(sb, "")
+	d.AddDeletedFilter(sb, opts.Deleted)
+	if !opts.AsOf.IsZero() {
+		err = d.AddAsOf(sb, opts.AsOf)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	query, err := sb.ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	d.debugLog.Printf("GetMap: query: %s, args: %v", query, pk)
+	d.debugLog.Printf("GetMap: query: %s, args: %v", query, keyValues)
 
-	row := tx.QueryRowx(query, pk)
+	row := tx.QueryRowx(query, keyValues...)
 	if row == nil {
 		return nil, sql.ErrNoRows
 	}
 
+	types, err := columnTypes(row)
+	if err != nil {
+		return nil, err
+	}
+
 	ret := make(map[string]interface{})
 
 	err = row.MapScan(ret)
 	if err != nil {
 		return nil, err
 	}
+	typeMap(types, ret)
 
 	d.debugLog.Printf("GetMap: ret: %v", ret)
 
-	if withRefTables {
+	if opts.WithRefTables {
+		// Referencing rows are soft deleted along with this row, so when
+		// showing a deleted row show its deleted references too
+		refDeleted := ExcludeDeleted
+		if ret[DeletedAtField] != nil {
+			refDeleted = WithDeleted
+		}
+
 		// Check for references from other tables
-		for _, ref := range d.config.GetBackReferences(table) {
+		for _, ref := range d.getConfig().GetBackReferences(table) {
 			// fmt.Printf("A. BackRef: %v\n", ref)
 			ssb := &SelectBuilder{
 				From:  ref.SourceTable,
 				Where: []string{tableFieldWrapped(ref.SourceTable, ref.SourceField) + "=?"},
 			}
 			d.AddRefLabels(ssb, sb.From)
+			d.AddDeletedFilter(ssb, refDeleted)
+			if !opts.AsOf.IsZero() {
+				err = d.AddAsOf(ssb, opts.AsOf)
+				if err != nil {
+					return nil, err
+				}
+			}
 			query, err := ssb.ToSql()
 			if err != nil {
 				return nil, err
@@ -65,6 +120,11 @@ func (d *Database) GetMap(table string, pk interface{}, withRefTables bool) (map
 			if err != nil {
 				return nil, fmt.Errorf("sub-query '%s': %w", ref.SourceTable, err)
 			}
+			subTypes, err := columnTypes(rows)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("sub-query '%s': %w", ref.SourceTable, err)
+			}
 
 			subRet := make([]map[string]interface{}, 0)
 			for rows.Next() {
@@ -73,11 +133,14 @@ func (d *Database) GetMap(table string, pk interface{}, withRefTables bool) (map
 				err = rows.MapScan(m)
 				// d.debugLog.Printf("E. sub-query: %v\n", m)
 				if err != nil {
+					rows.Close()
 					return nil, fmt.Errorf("sub-query '%s': %w", ref.SourceTable, err)
 				}
+				typeMap(subTypes, m)
 				subRet = append(subRet, m)
 				// d.debugLog.Printf("F: sub-query: add %v\n", m)
 			}
+			rows.Close()
 			refFieldName := ref.SourceTable + RefTableSuffix
 			if _, exists := ret[refFieldName]; exists {
 				refFieldName = ref.SourceTable + "_" + ref.SourceField + RefTableSuffix
@@ -19,7 +19,7 @@ type Map map[string]interface{}
 
 type HookParams struct {
 	Table  string
-	Key    interface{}
+	Key    interface{} // Key value of the row, nil before an insert
 	Data   Map
 	Action HookAction
 	Tx     *sqlx.Tx
@@ -130,6 +130,13 @@ func (d *Database) AddHook(table string, fn HookFn) {
 	})
 }
 
+// runAfterHooks publishes the committed change and then runs the after hooks, it must
+// only be called once the change has been committed
+func (d *Database) runAfterHooks(table string, params HookParams) error {
+	d.publishHook(params)
+	return d.runHooks(table, params)
+}
+
 func (d *Database) runHooks(table string, params HookParams) error {
 	var err error
 	for _, hook := range d.hooks {
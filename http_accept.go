@@ -0,0 +1,140 @@
+package sqliteapi
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats of the rows returned by HandleGetRows
+const (
+	FormatJSON   = "json"
+	FormatArray  = "array" // json array of arrays
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	FormatNDJSON = "ndjson"
+	FormatXML    = "xml"
+)
+
+var ErrNotAcceptable = errors.New("not acceptable")
+
+// formatContentTypes are the content types of the formats, in order of preference
+var formatContentTypes = []struct {
+	format      string
+	contentType string
+}{
+	{FormatJSON, "application/json"},
+	{FormatCSV, "text/csv"},
+	{FormatNDJSON, "application/x-ndjson"},
+	{FormatTSV, "text/tab-separated-values"},
+	{FormatXML, "application/xml"},
+	{FormatXML, "text/xml"},
+}
+
+// formatContentType returns the content type of the format
+func formatContentType(format string) string {
+	for _, f := range formatContentTypes {
+		if f.format == format {
+			return f.contentType
+		}
+	}
+	return "application/json"
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges of the Accept header, highest quality first
+func parseAccept(header string) []acceptRange {
+	ret := make([]acceptRange, 0)
+	for _, s := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ret = append(ret, acceptRange{mediaType, q})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].q > ret[j].q
+	})
+	return ret
+}
+
+// negotiateFormat returns the format of the rows, being the ?format= query parameter if
+// given, otherwise the best match of the Accept header, defaulting to json. Ranges of
+// equal quality are matched in our order of preference, and xml is only chosen over
+// the other formats if it is one of the client's most preferred, as browsers accept
+// xml ahead of */*.
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		for _, f := range formatContentTypes {
+			if f.format == format {
+				return format, nil
+			}
+		}
+		if format == FormatArray {
+			return format, nil
+		}
+		return "", errors.New("unknown format '" + format + "'")
+	}
+
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return FormatJSON, nil
+	}
+	ranges := parseAccept(header)
+
+	type match struct {
+		index    int // In formatContentTypes
+		q        float64
+		demoted  bool
+		matching bool
+	}
+	better := func(a, b match) bool {
+		if !b.matching {
+			return true
+		}
+		if a.demoted != b.demoted {
+			return !a.demoted
+		}
+		if a.q != b.q {
+			return a.q > b.q
+		}
+		return a.index < b.index
+	}
+
+	best := match{}
+	for _, a := range ranges {
+		for i, f := range formatContentTypes {
+			if a.mediaType == "*/*" || a.mediaType == "application/*" {
+				if f.format != FormatJSON {
+					continue
+				}
+			} else if a.mediaType != f.contentType && a.mediaType != strings.Split(f.contentType, "/")[0]+"/*" {
+				continue
+			}
+			m := match{i, a.q, f.format == FormatXML && a.q < ranges[0].q, true}
+			if better(m, best) {
+				best = m
+			}
+		}
+	}
+	if !best.matching {
+		return "", ErrNotAcceptable
+	}
+	return formatContentTypes[best.index].format, nil
+}
@@ -0,0 +1,144 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                                     FormatJSON,
+		"*/*":                                  FormatJSON,
+		"text/csv":                             FormatCSV,
+		"text/csv;q=0.5, application/xml":      FormatXML,
+		"application/x-ndjson":                 FormatNDJSON,
+		"text/tab-separated-values, */*;q=0.1": FormatTSV,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": FormatJSON, // Browsers
+		"image/png, text/*;q=0.5":                    FormatCSV,
+		"text/csv;q=0, application/json":             FormatJSON,
+		"application/xml, application/json":          FormatJSON,
+		"text/tab-separated-values, text/csv":        FormatCSV,
+		"text/html, application/xml;q=0.9":           FormatXML,
+		"application/xml;q=0.9, text/csv;q=0.5":      FormatXML,
+		"text/csv, application/xml;q=0.9, */*;q=0.1": FormatCSV,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/table1", nil)
+		r.Header.Set("Accept", accept)
+		format, err := negotiateFormat(r)
+		assert.NoError(t, err, accept)
+		assert.Equal(t, expected, format, accept)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/table1", nil)
+	r.Header.Set("Accept", "image/png")
+	_, err := negotiateFormat(r)
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// The query parameter overrides the header
+	r = httptest.NewRequest(http.MethodGet, "/table1?format=array", nil)
+	r.Header.Set("Accept", "text/csv")
+	format, err := negotiateFormat(r)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatArray, format)
+}
+
+func TestHandleGetRowsFormats(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+    note:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, name := range []string{"Fred", "Bert\tJones", "A & B"} {
+		_, err = db.InsertMap("customer", map[string]interface{}{"name": name}, nil)
+		assert.NoError(t, err)
+	}
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	get := func(accept string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/customer?select=id,name,note", nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res, string(b)
+	}
+
+	res, body := get("text/csv")
+	assert.Equal(t, "text/csv", res.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept", res.Header.Get("Vary"))
+	assert.Equal(t, "id,name,note\n1,Fred,\n2,Bert\tJones,\n3,A & B,\n", body)
+
+	res, body = get("text/tab-separated-values")
+	assert.Equal(t, "text/tab-separated-values", res.Header.Get("Content-Type"))
+	assert.Equal(t, "id\tname\tnote\n1\tFred\t\n2\t\"Bert\tJones\"\t\n3\tA & B\t\n", body)
+
+	res, body = get("application/xml")
+	assert.Equal(t, "application/xml", res.Header.Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<rows><row><id>1</id><name>Fred</name></row><row><id>2</id><name>Bert&#x9;Jones</name></row>`+
+		`<row><id>3</id><name>A &amp; B</name></row></rows>`, body)
+
+	res, body = get("application/x-ndjson")
+	assert.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	assert.Len(t, lines, 3)
+	var row map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &row))
+	assert.Equal(t, "A & B", row["name"])
+
+	res, _ = get("image/png")
+	assert.Equal(t, http.StatusNotAcceptable, res.StatusCode)
+}
+
+func TestQueryNdjsonWriterFlushes(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = db.InsertMap("customer", map[string]interface{}{"name": "Fred"}, nil)
+		assert.NoError(t, err)
+	}
+
+	defer func(n int) { NdjsonFlushRows = n }(NdjsonFlushRows)
+	NdjsonFlushRows = 2
+
+	w := &countingFlusher{ResponseRecorder: httptest.NewRecorder()}
+	assert.NoError(t, db.QueryNdjsonWriter(w, "SELECT * FROM customer", nil))
+	assert.Equal(t, 3, w.flushes)
+	assert.Equal(t, 5, strings.Count(w.Body.String(), "\n"))
+}
+
+type countingFlusher struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *countingFlusher) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
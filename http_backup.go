@@ -0,0 +1,74 @@
+package sqliteapi
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BackupTempDir is where backups are written before being downloaded, defaults to the
+// system temp dir
+var BackupTempDir = ""
+
+// HandleGetBackup downloads a consistent snapshot of the database, gzipped if ?gzip
+// is given. Admin only, see the RequestUser option.
+func (d *Database) HandleGetBackup(w http.ResponseWriter, r *http.Request) {
+	if !d.isAdminRequest(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	f, err := ioutil.TempFile(BackupTempDir, "sqliteapi-backup-*.db")
+	if err != nil {
+		d.log.Printf("GetBackup: Error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	// Removed however the request ends, including the client disconnecting
+	defer os.Remove(f.Name())
+
+	err = d.BackupContext(r.Context(), f.Name(), nil)
+	if err != nil {
+		d.log.Printf("GetBackup: Error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		d.log.Printf("GetBackup: Error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	filename := "backup-" + time.Now().UTC().Format(BackupTimeFormat) + ".db"
+	useGzip := r.URL.Query().Has("gzip")
+	if useGzip {
+		filename += ".gz"
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	if !useGzip {
+		if fi, err := f.Stat(); err == nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		}
+		_, err = io.Copy(w, f)
+	} else {
+		zw := gzip.NewWriter(w)
+		_, err = io.Copy(zw, f)
+		if err == nil {
+			err = zw.Close()
+		}
+	}
+	if err != nil {
+		d.log.Printf("GetBackup: download failed: %s", err)
+	}
+}
@@ -0,0 +1,32 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HandlePostBatch runs a json array of BatchOp's in a single transaction and
+// returns the json array of BatchResult's
+func (d *Database) HandlePostBatch(w http.ResponseWriter, r *http.Request) {
+	ops := make([]BatchOp, 0)
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&ops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// user := auth.GetUser(r)
+	var user User // BLANK USER
+
+	results, err := d.Batch(ops, user)
+	if err != nil {
+		d.log.Printf("Batch: error: %v", err)
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(results)
+}
@@ -0,0 +1,60 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HandleGetChanges returns the rows changed after ?since=seq, see Changes. Optionally
+// ?limit= and ?tables=a,b restrict the changes returned.
+func (d *Database) HandleGetChanges(w http.ResponseWriter, r *http.Request) {
+	// user := auth.GetUser(r)
+
+	var since int64
+	var limit int
+	var err error
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tables := []string{}
+	if s := r.URL.Query().Get("tables"); s != "" {
+		for _, table := range strings.Split(s, ",") {
+			if d.dbInfo.GetTableInfo(table) == nil {
+				http.Error(w, fmt.Sprintf("invalid table name '%s'", table), http.StatusBadRequest)
+				return
+			}
+			tables = append(tables, table)
+		}
+	}
+
+	changes, err := d.Changes(since, limit, tables...)
+	if err != nil {
+		d.log.Printf("GetChanges: Error: %s", err)
+		if errors.Is(err, ErrChangesCompacted) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(changes)
+}
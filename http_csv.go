@@ -0,0 +1,58 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// HandlePostCSV imports the text/csv body into the table, see ImportCSV. Headers are
+// mapped to fields with ?map=header:field (repeated or comma separated), and ?upsert,
+// ?dryRun and ?allOrNothing set the options. Responds with the json report, with a
+// 400 status if any row failed.
+func (d *Database) HandlePostCSV(w http.ResponseWriter, r *http.Request) {
+	table := path.Base(r.URL.Path)
+	if !regName.MatchString(table) {
+		http.Error(w, "invalid table/view", http.StatusBadRequest)
+		return
+	}
+
+	// user := auth.GetUser(r)
+	var user User // BLANK USER
+
+	q := r.URL.Query()
+	opts := &CSVImportOptions{
+		Mapping:      make(map[string]string),
+		DryRun:       q.Has("dryRun"),
+		AllOrNothing: q.Has("allOrNothing"),
+		User:         user,
+	}
+	if s := q.Get("upsert"); s != "" {
+		opts.Upsert = strings.Split(s, ",")
+	}
+	for _, m := range q["map"] {
+		for _, pair := range strings.Split(m, ",") {
+			header, field, ok := strings.Cut(pair, ":")
+			if !ok {
+				http.Error(w, "invalid map '"+pair+"', expected header:field", http.StatusBadRequest)
+				return
+			}
+			opts.Mapping[header] = field
+		}
+	}
+
+	report, err := d.ImportCSV(table, r.Body, opts)
+	if err != nil {
+		d.log.Printf("%s: Error importing csv: %v", table, err)
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+		return
+	}
+	d.log.Printf("%s: Imported csv, %d inserted, %d updated, %d errors", table, report.Inserted, report.Updated, len(report.Errors))
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(report)
+}
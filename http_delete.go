@@ -6,6 +6,7 @@ import (
 	"path"
 )
 
+// HandleDelRow deletes the row, or permanently deletes it when ?purge is given
 func (d *Database) HandleDelRow(w http.ResponseWriter, r *http.Request) {
 	table := path.Base(path.Dir(r.URL.Path))
 	if !regName.MatchString(table) {
@@ -17,7 +18,38 @@ func (d *Database) HandleDelRow(w http.ResponseWriter, r *http.Request) {
 
 	// user := auth.GetUser(r)
 
-	err := d.Delete(table, key, nil)
+	var err error
+	if r.URL.Query().Has("purge") {
+		err = d.Purge(table, key, nil)
+	} else {
+		err = d.Delete(table, key, nil)
+	}
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			http.Error(w, d.humaniseSqlError(err), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ErrSeedProtected) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+	}
+}
+
+// HandleRestoreRow restores a soft deleted row
+func (d *Database) HandleRestoreRow(w http.ResponseWriter, r *http.Request) {
+	table := path.Base(path.Dir(r.URL.Path))
+	if !regName.MatchString(table) {
+		http.Error(w, "invalid table/view", http.StatusBadRequest)
+		return
+	}
+
+	key := path.Base(r.URL.Path)
+
+	// user := auth.GetUser(r)
+
+	err := d.RestoreRow(table, key, nil)
 	if err != nil {
 		if errors.Is(err, ErrUnknownKey) {
 			http.Error(w, d.humaniseSqlError(err), http.StatusNotFound)
@@ -0,0 +1,215 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventsHeartbeat is how often a comment is sent to keep an idle event stream open
+var EventsHeartbeat = 15 * time.Second
+
+// HandleGetEvents streams the committed changes of the tables given by ?tables=a,b
+// (or all tables) as server-sent events. The stream resumes after the Last-Event-ID
+// header if given, and ?data=false excludes the row data.
+func (d *Database) HandleGetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// user := auth.GetUser(r)
+
+	tables := []string{}
+	if s := r.URL.Query().Get("tables"); s != "" {
+		for _, table := range strings.Split(s, ",") {
+			if d.dbInfo.GetTableInfo(table) == nil {
+				http.Error(w, fmt.Sprintf("invalid table name '%s'", table), http.StatusBadRequest)
+				return
+			}
+			tables = append(tables, table)
+		}
+	} else {
+		for _, info := range d.dbInfo {
+			tables = append(tables, info.Name)
+		}
+		sort.Strings(tables)
+	}
+
+	var lastID uint64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		var err error
+		lastID, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	withData := r.URL.Query().Get("data") != "false"
+
+	missed, events, cancel := d.SubscribeSince(lastID, tables...)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(e ChangeEvent) error {
+		if withData {
+			e.Data = d.readableData(e.Table, e.Data)
+		} else {
+			e.Data = nil
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, b)
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, e := range missed {
+		if send(e) != nil {
+			return
+		}
+		lastID = e.ID
+	}
+
+	heartbeat := time.NewTicker(EventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case e, ok := <-events:
+			if !ok {
+				return // Dropped for being too slow, the client will reconnect and resume
+			}
+			if e.ID <= lastID {
+				continue // Already sent
+			}
+			if send(e) != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// readableData returns a copy of the data without the hidden fields, including those
+// of any xxx_RefTable rows
+func (d *Database) readableData(table string, data Map) Map {
+	if data == nil {
+		return nil
+	}
+	ret := make(Map, len(data))
+	for k, v := range data {
+		if strings.HasSuffix(k, RefTableSuffix) {
+			refTable := strings.TrimSuffix(k, RefTableSuffix)
+			if rows, err := interfaceToArrayMapStringInterface(v); err == nil {
+				refRows := make([]Map, len(rows))
+				for i, row := range rows {
+					refRows[i] = d.readableData(refTable, row)
+				}
+				ret[k] = refRows
+			}
+			continue
+		}
+		if d.IsFieldReadable(table, k) {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// HandleGetRowsLive streams the changes to the result of the list query as server-sent
+// events, each event being a QueryDiff with the first containing all the rows as added
+func (d *Database) HandleGetRowsLive(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// user := auth.GetUser(r)
+
+	sb, args, err := d.SelectBuilderFromRequest(r, false)
+	if err != nil {
+		d.log.Printf("GetRowsLive: bad request: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.AddRefLabels(sb, "")
+
+	diffs, cancel, err := d.SubscribeQuery(sb, args)
+	if err != nil {
+		d.log.Printf("GetRowsLive: bad request: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(EventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case diff, ok := <-diffs:
+			if !ok {
+				return // Dropped for being too slow, the client will reconnect
+			}
+			for i := range diff.Added {
+				diff.Added[i] = d.readableData(sb.From, diff.Added[i])
+			}
+			for i := range diff.Changed {
+				diff.Changed[i] = d.readableData(sb.From, diff.Changed[i])
+			}
+			b, err := json.Marshal(diff)
+			if err != nil {
+				d.log.Printf("GetRowsLive: error: %s", err)
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", diff.ID, b)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
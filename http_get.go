@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"path"
 	"sort"
-	"strings"
 )
 
 func (d *Database) HandleGetTableNames(w http.ResponseWriter, r *http.Request) {
@@ -23,13 +22,35 @@ func (d *Database) HandleGetTableNames(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *Database) HandleGetRow(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("history") {
+		d.HandleGetRowHistory(w, r)
+		return
+	}
 
 	pk := path.Base(r.URL.Path)
 	table := path.Base(path.Dir(r.URL.Path))
 
 	d.debugLog.Printf("GetRow: Table: %s: PK Field: %s", table, pk)
 
-	m, err := d.GetMap(table, pk, r.URL.Query().Has("withRefTable"))
+	opts := GetOptions{
+		WithRefTables: r.URL.Query().Has("withRefTable"),
+	}
+	switch {
+	case r.URL.Query().Has("onlyDeleted"):
+		opts.Deleted = OnlyDeleted
+	case r.URL.Query().Has("withDeleted"):
+		opts.Deleted = WithDeleted
+	}
+	if s := r.URL.Query().Get("asOf"); s != "" {
+		var err error
+		opts.AsOf, err = ParseAsOf(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	m, err := d.GetMapWithOptions(table, pk, opts)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -45,11 +66,32 @@ func (d *Database) HandleGetRow(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(m)
 }
 
+// HandleGetRowHistory returns the audit entries of the row oldest first
+func (d *Database) HandleGetRowHistory(w http.ResponseWriter, r *http.Request) {
+	pk := path.Base(r.URL.Path)
+	table := path.Base(path.Dir(r.URL.Path))
+
+	entries, err := d.History(table, pk)
+	if err != nil {
+		d.log.Printf("GetRowHistory: Error: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(entries)
+}
+
 func (d *Database) HandleGetRows(w http.ResponseWriter, r *http.Request) {
 	if r.URL.RawQuery == "info" {
 		d.HandleGetRowsInfo(w, r)
 		return
 	}
+	if r.URL.Query().Has("live") {
+		d.HandleGetRowsLive(w, r)
+		return
+	}
 
 	sb, args, err := d.SelectBuilderFromRequest(r, false)
 	if err != nil {
@@ -72,21 +114,38 @@ func (d *Database) HandleGetRows(w http.ResponseWriter, r *http.Request) {
 
 	d.debugLog.Printf("GetRows: SQL:\n%s\nArgs: %s", q, args)
 
-	// @TODO Maybe change this to use Content-Type ?
-	switch strings.ToLower(r.URL.Query().Get("format")) {
-	case "csv":
-		if fname := r.URL.Query().Get("filename"); fname != "" {
-			w.Header().Set("Content-Disposition", `attachment; filename="`+fname+`"`)
-		}
-		w.Header().Set("Content-Type", "text/csv")
+	format, err := negotiateFormat(r)
+	if errors.Is(err, ErrNotAcceptable) {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Content-Type", formatContentType(format))
+	if fname := r.URL.Query().Get("filename"); fname != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+fname+`"`)
+	}
+
+	switch format {
+	case FormatCSV:
 		err = d.QueryCsvWriter(w, q, args)
 
-	case "array":
-		w.Header().Set("Content-Type", "application/json")
+	case FormatTSV:
+		err = d.QueryTsvWriter(w, q, args)
+
+	case FormatNDJSON:
+		err = d.QueryNdjsonWriter(w, q, args)
+
+	case FormatXML:
+		err = d.QueryXmlWriter(w, q, args)
+
+	case FormatArray:
 		err = d.QueryJsonArrayWriter(w, q, args)
 
 	default:
-		w.Header().Set("Content-Type", "application/json")
 		err = d.QueryJsonWriter(w, q, args)
 	}
 
@@ -111,7 +170,7 @@ func (d *Database) HandleGetRowsInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the actual database info from dbinfo, and then add the extra info from config
-	ct := d.config.GetTable(table)
+	ct := d.getConfig().GetTable(table)
 	ret := make([]TableFieldInfoWithMetaData, 0)
 	for _, tf := range tableInfo.Fields {
 		x := TableFieldInfoWithMetaData{
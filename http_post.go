@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"path"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -18,6 +19,11 @@ func (d *Database) HandlePostTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		d.HandlePostCSV(w, r)
+		return
+	}
+
 	dec := json.NewDecoder(r.Body)
 	data := make(map[string]interface{})
 	err := dec.Decode(&data)
@@ -29,6 +35,25 @@ func (d *Database) HandlePostTable(w http.ResponseWriter, r *http.Request) {
 	// user := auth.GetUser(r)
 	var user User // BLANK USER
 
+	if s := r.URL.Query().Get("upsert"); s != "" {
+		id, inserted, err := d.UpsertMap(table, data, strings.Split(s, ","), user)
+		if err != nil {
+			d.log.Printf("%s: Error upserting row: %v", table, err)
+			http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+			return
+		}
+
+		key := d.insertedKey(table, data, id)
+		w.Write([]byte(fmt.Sprintf("%v", key)))
+
+		if inserted {
+			d.log.Printf("%s: Created row %v", table, key)
+		} else {
+			d.log.Printf("%s: Updated row %v", table, key)
+		}
+		return
+	}
+
 	id, err := d.InsertMap(table, data, user)
 	if err != nil {
 		d.log.Printf("%s: Error creating row: %v", table, err)
@@ -36,9 +61,10 @@ func (d *Database) HandlePostTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write([]byte(fmt.Sprintf("%d", id)))
+	key := d.insertedKey(table, data, id)
+	w.Write([]byte(fmt.Sprintf("%v", key)))
 
-	d.log.Printf("%s: Created row %d", table, id)
+	d.log.Printf("%s: Created row %v", table, key)
 }
 
 type PostSQLStruct struct {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"path"
+	"strings"
 )
 
 func (d *Database) HandlePutRow(w http.ResponseWriter, r *http.Request) {
@@ -20,6 +21,7 @@ func (d *Database) HandlePutRow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	key := path.Base(r.URL.Path)
+	keyFields := strings.Join(tableInfo.GetPrimaryKeyFields(), ",")
 
 	dec := json.NewDecoder(r.Body)
 	data := make(map[string]interface{})
@@ -31,17 +33,37 @@ func (d *Database) HandlePutRow(w http.ResponseWriter, r *http.Request) {
 	}
 	// @TODO Should I check the data id?
 
-	data[tableInfo.GetPrimaryKey().Field] = key
+	err = tableInfo.SetKey(data, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// user := auth.GetUser(r)
 	var user User // BLANK USER
 
+	if r.URL.Query().Has("upsert") { // Create the row if the key does not exist
+		_, inserted, err := d.UpsertMap(table, data, tableInfo.GetPrimaryKeyFields(), user)
+		if err != nil {
+			d.log.Printf("%s: Error upserting row where %s = '%v': %v", table, keyFields, key, err)
+			http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+			return
+		}
+		if inserted {
+			w.WriteHeader(http.StatusCreated)
+			d.log.Printf("%s: Created row where %s = '%v'", table, keyFields, key)
+		} else {
+			d.log.Printf("%s: Updated row where %s = '%v'", table, keyFields, key)
+		}
+		return
+	}
+
 	err = d.UpdateMap(table, data, user)
 	if err != nil {
-		d.log.Printf("%s: Error updating row where %s = '%v': %v", table, tableInfo.GetPrimaryKey().Field, key, err)
+		d.log.Printf("%s: Error updating row where %s = '%v': %v", table, keyFields, key, err)
 		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
 		return
 	}
 
-	d.log.Printf("%s: Updated row where %s = '%v'", table, tableInfo.GetPrimaryKey().Field, key)
+	d.log.Printf("%s: Updated row where %s = '%v'", table, keyFields, key)
 }
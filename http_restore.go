@@ -0,0 +1,86 @@
+package sqliteapi
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RestoreMaxBytes is the largest backup, or multipart form, that can be uploaded to
+// HandlePostRestore
+var RestoreMaxBytes int64 = 1 << 30
+
+// HandlePostRestore replaces the database with the uploaded backup, sent either as the
+// request body or the "file" field of a multipart form, and gunzipped if ?gzip is given.
+// Admin only, see the RequestUser option.
+func (d *Database) HandlePostRestore(w http.ResponseWriter, r *http.Request) {
+	if !d.isAdminRequest(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, RestoreMaxBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), restoreUploadStatus(err))
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	if r.URL.Query().Has("gzip") {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	// Also limits the size once gunzipped
+	body = io.LimitReader(body, RestoreMaxBytes+1)
+
+	f, err := ioutil.TempFile(BackupTempDir, "sqliteapi-restore-*.db")
+	if err != nil {
+		d.log.Printf("PostRestore: Error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, body)
+	f.Close()
+	if err != nil {
+		http.Error(w, err.Error(), restoreUploadStatus(err))
+		return
+	}
+	if n > RestoreMaxBytes {
+		http.Error(w, "backup too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	err = d.Restore(f.Name())
+	if errors.Is(err, ErrInvalidBackup) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		d.log.Printf("PostRestore: Error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// restoreUploadStatus returns the http status of the error reading the upload
+func restoreUploadStatus(err error) int {
+	// http.MaxBytesError is only available from go 1.19
+	if strings.Contains(err.Error(), "request body too large") {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,37 @@
+package sqliteapi
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// HandleRevertRow reverts the row to the audit entry given by ?revert=auditId
+func (d *Database) HandleRevertRow(w http.ResponseWriter, r *http.Request) {
+	table := path.Base(path.Dir(r.URL.Path))
+	if !regName.MatchString(table) {
+		http.Error(w, "invalid table/view", http.StatusBadRequest)
+		return
+	}
+
+	key := path.Base(r.URL.Path)
+
+	auditId, err := strconv.ParseInt(r.URL.Query().Get("revert"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid audit id", http.StatusBadRequest)
+		return
+	}
+
+	// user := auth.GetUser(r)
+	var user User // BLANK USER
+
+	err = d.RevertRow(table, key, auditId, user)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrUnknownAuditEntry) {
+			http.Error(w, d.humaniseSqlError(err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,63 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// HandleGetWebhooks lists the webhook queue, optionally only those with ?status=,
+// for admins to find failed (dead) deliveries. Admin only, see the RequestUser option.
+func (d *Database) HandleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !d.isAdminRequest(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := d.WebhookQueue(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		d.log.Printf("GetWebhooks: Error: %s", err)
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(deliveries)
+}
+
+// HandleRedeliverWebhook queues the delivery given by /_webhooks/{id}?redeliver to be
+// sent again. Admin only, see the RequestUser option.
+func (d *Database) HandleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if !d.isAdminRequest(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseInt(path.Base(r.URL.Path), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid webhook delivery id", http.StatusBadRequest)
+		return
+	}
+
+	err = d.RedeliverWebhook(id)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			http.Error(w, d.humaniseSqlError(err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, d.humaniseSqlError(err), http.StatusBadRequest)
+	}
+}
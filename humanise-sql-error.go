@@ -5,7 +5,7 @@ import (
 )
 
 func (d *Database) humaniseSqlError(err error) string {
-	if err == nil || d.config == nil {
+	if err == nil || d.getConfig() == nil {
 		return ""
 	}
 
@@ -14,7 +14,7 @@ func (d *Database) humaniseSqlError(err error) string {
 		label := strings.TrimSpace(splt[1]) // Default
 		tf := strings.SplitN(label, ".", 2)
 		if len(tf) == 2 {
-			table := d.config.GetTable(tf[0])
+			table := d.getConfig().GetTable(tf[0])
 			if table != nil {
 				for _, f := range table.Fields {
 					if f.Name == tf[1] {
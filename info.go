@@ -2,6 +2,7 @@ package sqliteapi
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -35,6 +36,15 @@ func (tis TableInfos) GetTableInfo(name string) *TableInfo {
 	return nil
 }
 
+func (ti TableInfo) HasField(name string) bool {
+	for _, f := range ti.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (ti TableInfo) GetPrimaryKey() ResultColumn {
 	for _, f := range ti.Fields {
 		if f.PrimaryKey > 0 {
@@ -50,6 +60,130 @@ func (ti TableInfo) GetPrimaryKey() ResultColumn {
 	}
 }
 
+// GetPrimaryKeys returns all the primary key columns in key order. Tables
+// without a primary key return the same placeholder column as GetPrimaryKey.
+func (ti TableInfo) GetPrimaryKeys() []ResultColumn {
+	pks := make([]TableFieldInfo, 0)
+	for _, f := range ti.Fields {
+		if f.PrimaryKey > 0 {
+			pks = append(pks, f)
+		}
+	}
+	if len(pks) == 0 {
+		return []ResultColumn{ti.GetPrimaryKey()}
+	}
+	sort.Slice(pks, func(a, b int) bool {
+		return pks[a].PrimaryKey < pks[b].PrimaryKey
+	})
+	ret := make([]ResultColumn, len(pks))
+	for i, f := range pks {
+		ret[i] = ResultColumn{
+			Table: ti.Name,
+			Field: f.Name,
+		}
+	}
+	return ret
+}
+
+// GetPrimaryKeyFields returns the names of the primary key fields in key order
+func (ti TableInfo) GetPrimaryKeyFields() []string {
+	ret := make([]string, 0)
+	for _, pk := range ti.GetPrimaryKeys() {
+		ret = append(ret, pk.Field)
+	}
+	return ret
+}
+
+// KeyWhere returns a where condition matching all the primary key fields e.g.
+// `table`.`a`=? AND `table`.`b`=?
+func (ti TableInfo) KeyWhere() string {
+	s := []string{}
+	for _, pk := range ti.GetPrimaryKeys() {
+		s = append(s, pk.String()+"=?")
+	}
+	return strings.Join(s, " AND ")
+}
+
+// KeyValues returns one value per primary key field from the given key, which can
+// be a single value, a slice of values or a map of field names to values. For composite
+// keys a string key can be comma separated e.g. "1,5" or matrix params e.g. "a=1;b=5".
+func (ti TableInfo) KeyValues(key interface{}) ([]interface{}, error) {
+	pks := ti.GetPrimaryKeyFields()
+	values := make([]interface{}, 0)
+
+	switch k := key.(type) {
+	case []interface{}:
+		values = append(values, k...)
+
+	case []string:
+		for _, v := range k {
+			values = append(values, v)
+		}
+
+	case map[string]interface{}:
+		for _, pk := range pks {
+			v, ok := k[pk]
+			if !ok {
+				return nil, fmt.Errorf("%w: missing value for '%s'", ErrInvalidKey, pk)
+			}
+			values = append(values, v)
+		}
+
+	case string:
+		if len(pks) < 2 {
+			values = append(values, k)
+		} else if strings.Contains(k, "=") {
+			m := make(map[string]interface{})
+			for _, param := range strings.Split(k, ";") {
+				kv := strings.SplitN(param, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("%w: '%s'", ErrInvalidKey, k)
+				}
+				m[kv[0]] = kv[1]
+			}
+			return ti.KeyValues(m)
+		} else {
+			for _, v := range strings.Split(k, ",") {
+				values = append(values, v)
+			}
+		}
+
+	default:
+		values = append(values, key)
+	}
+
+	if len(values) != len(pks) {
+		return nil, fmt.Errorf("%w: expected %d values, got %d", ErrInvalidKey, len(pks), len(values))
+	}
+	return values, nil
+}
+
+// SetKey sets the primary key fields in data from the given key
+func (ti TableInfo) SetKey(data map[string]interface{}, key interface{}) error {
+	values, err := ti.KeyValues(key)
+	if err != nil {
+		return err
+	}
+	for i, pk := range ti.GetPrimaryKeyFields() {
+		data[pk] = values[i]
+	}
+	return nil
+}
+
+// GetKey returns the key from the primary key fields in data, as a single value
+// or a slice of values for composite keys
+func (ti TableInfo) GetKey(data map[string]interface{}) interface{} {
+	pks := ti.GetPrimaryKeyFields()
+	if len(pks) == 1 {
+		return data[pks[0]]
+	}
+	ret := make([]interface{}, len(pks))
+	for i, pk := range pks {
+		ret[i] = data[pk]
+	}
+	return ret
+}
+
 func (tis TableInfos) String() string {
 	s := ""
 	for _, ti := range tis {
@@ -113,13 +247,16 @@ func (d *Database) Refresh() error {
 			if err != nil {
 				return err
 			}
-			if f.Name == "id" && f.PrimaryKey > 0 {
+			f.Type = strings.ToLower(f.Type)
+			if f.Name == "id" && f.PrimaryKey > 0 && f.Type == "integer" { // Alias of the rowid
 				table.IsPrimaryKeyId = true
 			}
-			f.Type = strings.ToLower(f.Type)
 			f.DefaultValue = removeQuotesIfString(f.DefaultValue)
 			table.Fields = append(table.Fields, f)
 		}
+		if len(table.GetPrimaryKeyFields()) > 1 { // Composite keys are not autoincrement
+			table.IsPrimaryKeyId = false
+		}
 
 		info[n] = table
 	}
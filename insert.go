@@ -11,19 +11,23 @@ import (
 
 var ErrUnknownKey = errors.New("unknown key")
 var ErrUnknownTable = errors.New("unknown table/view")
+var ErrInvalidKey = errors.New("invalid key")
 
 // InsertMap inserts the map including referenced table (xxx_RefTable), and updates
 // data["id"] field if there is an autoincrement primary key
 func (d *Database) InsertMap(table string, data map[string]interface{}, user User) (int64, error) {
-	ctx, _ := context.WithTimeout(context.Background(), d.timeout)
-	tx, err := d.DB.BeginTxx(ctx, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
 	if err != nil {
 		return 0, err
 	}
+	defer done()
 
 	err = d.runHooks(table, HookParams{table, nil, data, HookBeforeInsert, tx, user})
 	if err != nil {
 		d.log.Printf("error running before before hook: %s", err)
+		tx.Rollback()
 		return 0, err
 	}
 
@@ -35,11 +39,12 @@ func (d *Database) InsertMap(table string, data map[string]interface{}, user Use
 	}
 
 	err = tx.Commit()
+	done()
 	if err != nil {
 		return 0, err
 	}
 
-	err = d.runHooks(table, HookParams{table, id, data, HookAfterInsert, tx, user})
+	err = d.runAfterHooks(table, HookParams{table, id, data, HookAfterInsert, tx, user})
 	if err != nil {
 		d.log.Printf("error running before before hook: %s", err)
 		return 0, err
@@ -67,7 +72,19 @@ func (d *Database) insertMapWithTx(tx *sqlx.Tx, table string, data map[string]in
 	// Unused data fields which we'll check later for joined tables
 	unusedDataFields := make([]string, 0)
 
-	var err error
+	// Generated fields are always written, even if readonly
+	generated, err := d.generateFields(table, data)
+	if err != nil {
+		return 0, err
+	}
+	isGenerated := func(k string) bool {
+		for _, g := range generated {
+			if g == k {
+				return true
+			}
+		}
+		return false
+	}
 
 	// Populate the fields & args arrays
 	for k, v := range data {
@@ -78,12 +95,13 @@ func (d *Database) insertMapWithTx(tx *sqlx.Tx, table string, data map[string]in
 				if f.PrimaryKey > 0 && tableInfo.IsPrimaryKeyId {
 					continue // Do insert as autoinc value
 				}
-				if d.IsFieldWritable(table, k) { // And are writable
+				if d.IsFieldWritable(table, k) || isGenerated(k) { // And are writable
 					err = d.FieldValidation(table, k, v)
 					if err != nil {
 						return 0, err
 					}
 					fields = append(fields, k)
+					v = d.fieldValue(table, k, v)
 					switch v.(type) {
 					case []interface{}:
 						values = append(values, "")
@@ -116,19 +134,26 @@ func (d *Database) insertMapWithTx(tx *sqlx.Tx, table string, data map[string]in
 
 	v, err := res.LastInsertId()
 	id := v
-	if err == nil {
+	if err == nil && (tableInfo.IsPrimaryKeyId || !tableInfo.HasField("id")) {
 		data["id"] = id
 	}
 
+	if err == nil {
+		err = d.auditInsertWithTx(tx, table, v, user)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	// Handle joined tables if data exists
-	if d.config != nil {
+	if d.getConfig() != nil {
 		// logf("unused fields: %s", unusedDataFields)
 		for _, k := range unusedDataFields {
 			// See if the field name is a table e.g. "sessionThing_RefTable"
 			// logf("test : %s: %t", k, strings.HasSuffix(k, RefTableSuffix))
 			if strings.HasSuffix(k, RefTableSuffix) {
 				// logf("got join table field: %s", k)
-				if t := d.config.GetTable(strings.TrimSuffix(k, RefTableSuffix)); t != nil {
+				if t := d.getConfig().GetTable(strings.TrimSuffix(k, RefTableSuffix)); t != nil {
 					// logf("got join table %s", t.Name)
 					for _, f := range t.Fields {
 						//logf("link table %s, checking field %s: %s vs %s.", t.Name, f.Name, f.References, table)
@@ -0,0 +1,124 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// IsJSON returns true if the field is a json field
+func (f *ConfigField) IsJSON() bool {
+	return strings.EqualFold(f.Type, TypeJSON)
+}
+
+// isJSONField returns true if the field of the table is a json field
+func (d *Database) isJSONField(table string, field string) bool {
+	if d.getConfig() == nil {
+		return false
+	}
+	ct := d.getConfig().GetTable(table)
+	if ct == nil {
+		return false
+	}
+	for _, f := range ct.Fields {
+		if f.Name == field {
+			return f.IsJSON()
+		}
+	}
+	return false
+}
+
+// jsonText returns the value to store in a json field: a string (or []byte) must
+// already be json text, other values such as objects and arrays are encoded
+func jsonText(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case json.RawMessage:
+		v = string(x)
+	case []byte:
+		v = string(x)
+	case string:
+	default:
+		b, err := json.Marshal(x)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	if !json.Valid([]byte(v.(string))) {
+		return nil, errors.New("invalid json")
+	}
+	return v, nil
+}
+
+// fieldValue returns the value as it is stored in the field. An empty string is
+// stored as NULL in a json field, as it is not valid json.
+func (d *Database) fieldValue(table string, field string, v interface{}) interface{} {
+	if d.isJSONField(table, field) {
+		if v == "" {
+			return nil
+		}
+		if s, err := jsonText(v); err == nil {
+			return s
+		}
+	}
+	return v
+}
+
+// regJSONPath matches a field followed by a path of .key and [index] parts
+var regJSONPath = regexp.MustCompile(`^(\w+)((?:\.\w+|\[\d+\])+)`)
+
+// jsonPathExpr returns the json_extract() expression of a json path in a json field of
+// the table, e.g. attrs.colour or attrs.tags[0]
+func (d *Database) jsonPathExpr(table string, path string) (string, bool) {
+	m := regJSONPath.FindStringSubmatch(path)
+	if m == nil || len(m[0]) != len(path) || !d.isJSONField(table, m[1]) {
+		return "", false
+	}
+	return "json_extract(" + tableFieldWrapped(table, m[1]) + ",'$" + m[2] + "')", true
+}
+
+// replaceJSONPaths replaces the json paths in the sql where clause with json_extract(),
+// leaving quoted strings and identifiers as they are
+func (d *Database) replaceJSONPaths(table string, where string) string {
+	var sb strings.Builder
+	for i := 0; i < len(where); {
+		c := where[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(where[i+1:], c)
+			if end < 0 {
+				sb.WriteString(where[i:])
+				return sb.String()
+			}
+			sb.WriteString(where[i : i+end+2])
+			i += end + 2
+
+		case isWordChar(c) && (i == 0 || (!isWordChar(where[i-1]) && where[i-1] != '.')):
+			m := regJSONPath.FindString(where[i:])
+			if expr, ok := d.jsonPathExpr(table, m); ok {
+				sb.WriteString(expr)
+				i += len(m)
+				continue
+			}
+			// Skip the rest of the word
+			j := i
+			for j < len(where) && isWordChar(where[j]) {
+				j++
+			}
+			sb.WriteString(where[i:j])
+			i = j
+
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,118 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONField(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  product:
+    id:
+    name:
+    attrs:
+      type: json
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	post := func(body string) (int, string) {
+		res, err := http.Post(ts.URL+"/product", "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	status, _ := post(`{"name":"Hat","attrs":{"colour":"red","size":2,"tags":["a","b"]}}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = post(`{"name":"Scarf","attrs":{"colour":"blue","size":3,"tags":["b"]}}`)
+	assert.Equal(t, http.StatusOK, status)
+	// Json text is stored as is
+	status, _ = post(`{"name":"Glove","attrs":"{\"colour\":\"red\",\"size\":1}"}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, body := post(`{"name":"Sock","attrs":"{colour: red}"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "attrs: invalid json", body)
+	// An empty string is stored as NULL
+	status, _ = post(`{"name":"Belt","attrs":""}`)
+	assert.Equal(t, http.StatusOK, status)
+	var n int
+	assert.NoError(t, db.DB.Get(&n, "SELECT COUNT(*) FROM product WHERE name='Belt' AND attrs ISNULL"))
+	assert.Equal(t, 1, n)
+
+	// Other writers are checked by the database
+	_, err = db.DB.Exec("INSERT INTO product (name, attrs) VALUES ('Sock', '{colour: red}')")
+	assert.ErrorContains(t, err, "CHECK constraint failed")
+
+	var stored string
+	assert.NoError(t, db.DB.Get(&stored, "SELECT attrs FROM product WHERE id=1"))
+	assert.JSONEq(t, `{"colour":"red","size":2,"tags":["a","b"]}`, stored)
+
+	assert.NoError(t, db.UpdateMap("product", map[string]interface{}{"id": 1, "attrs": []interface{}{1, 2}}, nil))
+	m, err := db.GetMap("product", 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`[1,2]`), m["attrs"])
+
+	names := func(query url.Values) []string {
+		res, err := http.Get(ts.URL + "/product?" + query.Encode())
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		var rows []struct {
+			Name  string
+			Attrs map[string]interface{}
+		}
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&rows))
+		ret := []string{}
+		for _, row := range rows {
+			ret = append(ret, row.Name)
+		}
+		return ret
+	}
+
+	assert.NoError(t, db.UpdateMap("product", map[string]interface{}{"id": 1, "attrs": map[string]interface{}{"colour": "red", "size": 2, "tags": []string{"a", "b"}}}, nil))
+	assert.Equal(t, []string{"Glove", "Hat"}, names(url.Values{"where": {"attrs.colour='red'"}, "sort": {"attrs.size asc"}}))
+	assert.Equal(t, []string{"Scarf", "Hat", "Glove", "Belt"}, names(url.Values{"sort": {"attrs.size desc"}}))
+	assert.Equal(t, []string{"Scarf"}, names(url.Values{"where": {"attrs.tags[0]='b'"}}))
+	// Quoted strings are left as they are
+	assert.Equal(t, []string{}, names(url.Values{"where": {"name='attrs.colour'"}}))
+}
+
+func TestReplaceJSONPaths(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  product:
+    id:
+    attrs:
+      type: json
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for where, expected := range map[string]string{
+		"attrs.colour='red'":              "json_extract(`product`.`attrs`,'$.colour')='red'",
+		"attrs.a.b[2] > 1 AND id=2":       "json_extract(`product`.`attrs`,'$.a.b[2]') > 1 AND id=2",
+		"product.attrs.colour NOTNULL":    "product.attrs.colour NOTNULL",
+		"`attrs.colour`='x' OR x.attrs=1": "`attrs.colour`='x' OR x.attrs=1",
+		"other.colour=1.5":                "other.colour=1.5",
+		"'it''s attrs.colour'":            "'it''s attrs.colour'",
+	} {
+		assert.Equal(t, expected, db.replaceJSONPaths("product", where), where)
+	}
+}
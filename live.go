@@ -0,0 +1,178 @@
+package sqliteapi
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// QueryDiff is the change to the result of a live query, rows are identified by the
+// primary key of the queried table
+type QueryDiff struct {
+	ID      uint64                   `json:"id"`
+	Added   []map[string]interface{} `json:"added,omitempty"`
+	Changed []map[string]interface{} `json:"changed,omitempty"`
+	Removed []interface{}            `json:"removed,omitempty"` // Keys
+	Keys    []interface{}            `json:"keys"`              // Keys of all the rows in order
+}
+
+// SubscribeQuery runs the query built by sb and returns a channel which first receives
+// all the rows as added, then the rows added, changed and removed whenever a change to
+// the table, or a table it joins, changes the result. The channel is closed if the
+// subscriber is too slow, or when cancel is called.
+func (d *Database) SubscribeQuery(sb *SelectBuilder, args []interface{}) (<-chan QueryDiff, func(), error) {
+	ti := d.dbInfo.GetTableInfo(sb.From)
+	if ti == nil {
+		return nil, nil, ErrUnknownTable
+	}
+
+	// The primary key is needed to identify the rows
+	if len(sb.Select) > 0 {
+		for _, pk := range ti.GetPrimaryKeyFields() {
+			f := tableFieldWrapped(sb.From, pk)
+			found := false
+			for _, s := range sb.Select {
+				if s == f {
+					found = true
+					break
+				}
+			}
+			if !found {
+				sb.Select = append(sb.Select, f)
+			}
+		}
+	}
+
+	q, err := sb.ToSql()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := d.queryMaps(q, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tables := []string{sb.From}
+	for _, j := range sb.Joins {
+		tables = append(tables, j.Table)
+	}
+	events, cancelEvents := d.Subscribe(tables...)
+
+	out := make(chan QueryDiff, 1)
+	done := make(chan struct{})
+
+	lq := &liveQuery{
+		ti:   ti,
+		prev: make(map[string]map[string]interface{}),
+	}
+	out <- lq.diff(rows)
+
+	go func() {
+		defer close(out)
+		defer cancelEvents()
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+			}
+
+			// Coalesce any other waiting events into one re-run of the query
+		drain:
+			for {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						return
+					}
+				default:
+					break drain
+				}
+			}
+
+			rows, err := d.queryMaps(q, args)
+			if err != nil {
+				d.log.Printf("live query error: %s", err)
+				return
+			}
+			diff := lq.diff(rows)
+			if len(diff.Added)+len(diff.Changed)+len(diff.Removed) == 0 && !lq.reordered {
+				continue
+			}
+			select {
+			case out <- diff:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() { close(done) })
+	}
+
+	return out, cancel, nil
+}
+
+type liveQuery struct {
+	ti        *TableInfo
+	id        uint64
+	prev      map[string]map[string]interface{}
+	prevKeys  []string
+	reordered bool
+}
+
+// diff returns the difference between rows and the previous rows
+func (lq *liveQuery) diff(rows []map[string]interface{}) QueryDiff {
+	lq.id++
+	diff := QueryDiff{
+		ID:   lq.id,
+		Keys: make([]interface{}, len(rows)),
+	}
+	current := make(map[string]map[string]interface{}, len(rows))
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		key := lq.ti.GetKey(row)
+		diff.Keys[i] = key
+		keys[i] = fmt.Sprintf("%v", key)
+		current[keys[i]] = row
+		if prev, ok := lq.prev[keys[i]]; !ok {
+			diff.Added = append(diff.Added, row)
+		} else if !reflect.DeepEqual(prev, row) {
+			diff.Changed = append(diff.Changed, row)
+		}
+	}
+	for k, row := range lq.prev {
+		if _, ok := current[k]; !ok {
+			diff.Removed = append(diff.Removed, lq.ti.GetKey(row))
+		}
+	}
+	lq.reordered = !reflect.DeepEqual(keys, lq.prevKeys)
+	lq.prev = current
+	lq.prevKeys = keys
+	return diff
+}
+
+// queryMaps runs the query returning all the rows
+func (d *Database) queryMaps(query string, args []interface{}) ([]map[string]interface{}, error) {
+	rows, err := d.DB.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		m := make(map[string]interface{})
+		err = rows.MapScan(m)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, m)
+	}
+	return ret, rows.Err()
+}
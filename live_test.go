@@ -0,0 +1,145 @@
+package sqliteapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeQuery(t *testing.T) {
+	// The query is re-run on another connection, so the memory database must be shared
+	db, err := NewDatabase("file:TestSubscribeQuery?mode=memory&cache=shared",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+  invoice:
+    id:
+    customerId:
+      type: integer
+      ref: customer.id/name
+    total:
+      type: integer
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cid, err := db.InsertMap("customer", map[string]interface{}{"name": "Fred"}, nil)
+	assert.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		_, err = db.InsertMap("invoice", map[string]interface{}{"customerId": cid, "total": i}, nil)
+		assert.NoError(t, err)
+	}
+
+	sb := &SelectBuilder{
+		From:    "invoice",
+		OrderBy: []OrderBy{{Field: "id", Ascending: false}},
+		Limit:   2,
+	}
+	db.AddRefLabels(sb, "")
+
+	diffs, cancel, err := db.SubscribeQuery(sb, nil)
+	assert.NoError(t, err)
+
+	next := func() QueryDiff {
+		select {
+		case diff := <-diffs:
+			return diff
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for diff")
+		}
+		return QueryDiff{}
+	}
+
+	diff := next()
+	assert.Len(t, diff.Added, 2)
+	assert.Equal(t, []interface{}{int64(3), int64(2)}, diff.Keys)
+
+	// A new row pushes out the oldest
+	_, err = db.InsertMap("invoice", map[string]interface{}{"customerId": cid, "total": 4}, nil)
+	assert.NoError(t, err)
+	diff = next()
+	if assert.Len(t, diff.Added, 1) {
+		assert.Equal(t, int64(4), diff.Added[0]["id"])
+	}
+	assert.Equal(t, []interface{}{int64(2)}, diff.Removed)
+	assert.Len(t, diff.Changed, 0)
+
+	// Changes to a joined table are pushed
+	assert.NoError(t, db.UpdateMap("customer", map[string]interface{}{"id": cid, "name": "ACME"}, nil))
+	diff = next()
+	assert.Len(t, diff.Changed, 2)
+	assert.Equal(t, "ACME", diff.Changed[0]["customerId_RefLabel"])
+
+	// Changes outside of the result are not
+	assert.NoError(t, db.UpdateMap("invoice", map[string]interface{}{"id": 1, "total": 10}, nil))
+	assert.NoError(t, db.UpdateMap("invoice", map[string]interface{}{"id": 4, "total": 40}, nil))
+	diff = next()
+	if assert.Len(t, diff.Changed, 1) {
+		assert.Equal(t, int64(40), diff.Changed[0]["total"])
+	}
+
+	cancel()
+	cancel()
+	for range diffs {
+	}
+}
+
+func TestHandleGetRowsLive(t *testing.T) {
+	db, err := NewDatabase("file:TestHandleGetRowsLive?mode=memory&cache=shared",
+		YamlConfig([]byte(`
+tables:
+  invoice:
+    id:
+    total:
+      type: integer
+    secret:
+      hidden: true
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/invoice?live&sort=-id&limit=20")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	r := bufio.NewReader(res.Body)
+
+	readDiff := func() QueryDiff {
+		var diff QueryDiff
+		for {
+			line, err := r.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return diff
+			}
+			if strings.HasPrefix(line, "data: ") {
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &diff))
+				return diff
+			}
+		}
+	}
+
+	diff := readDiff()
+	assert.Len(t, diff.Added, 0)
+
+	_, err = db.InsertMap("invoice", map[string]interface{}{"total": 1, "secret": "xyz"}, nil)
+	assert.NoError(t, err)
+
+	diff = readDiff()
+	if assert.Len(t, diff.Added, 1) {
+		assert.Equal(t, float64(1), diff.Added[0]["total"])
+		assert.NotContains(t, diff.Added[0], "secret")
+	}
+}
@@ -1,6 +1,7 @@
 package sqliteapi
 
 import (
+	"net/http"
 	"time"
 )
 
@@ -27,7 +28,7 @@ func YamlConfig(b []byte) Option {
 			return err
 		}
 		d.debugLog.Println("Config:\n" + c.String())
-		d.config = c
+		d.setConfig(c)
 		return nil
 	}
 }
@@ -53,3 +54,13 @@ func DebugLog(logger SimpleLogger) Option {
 		return nil
 	}
 }
+
+// RequestUser sets how the user making a http request is found, returning nil if there
+// is none. Admin only endpoints such as /_backup are refused unless an admin user is
+// found, so are not available without it.
+func RequestUser(fn func(r *http.Request) User) Option {
+	return func(d *Database) error {
+		d.requestUser = fn
+		return nil
+	}
+}
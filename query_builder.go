@@ -35,10 +35,13 @@ func (d *Database) SelectBuilderFromRequest(r *http.Request, withKey bool) (*Sel
 	if withKey {
 		pkValue := sb.From
 		sb.From = path.Base(path.Dir(r.URL.Path))
-		ct := d.config.GetTable(sb.From)
-		if ct != nil {
-			sb.Where = append(sb.Where, EqualsArg(sb.From, ct.PrimaryKey()))
-			args = append(args, pkValue)
+		if ti := d.dbInfo.GetTableInfo(sb.From); ti != nil {
+			keyValues, err := ti.KeyValues(pkValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			sb.Where = append(sb.Where, ti.KeyWhere())
+			args = append(args, keyValues...)
 		}
 	}
 
@@ -65,7 +68,7 @@ func (d *Database) SelectBuilderFromRequest(r *http.Request, withKey bool) (*Sel
 	}
 
 	if s := r.URL.Query().Get("where"); s != "" {
-		sb.Where = append(sb.Where, s)
+		sb.Where = append(sb.Where, d.replaceJSONPaths(sb.From, s))
 	}
 
 	if s := r.URL.Query().Get("sort"); s != "" {
@@ -76,11 +79,35 @@ func (d *Database) SelectBuilderFromRequest(r *http.Request, withKey bool) (*Sel
 					Field:     m[2],
 					Ascending: strings.ToLower(m[3]) == "asc",
 				}
+				if expr, ok := d.jsonPathExpr(sb.From, m[2]); ok {
+					ob.Field = expr
+					ob.IsExpr = true
+				}
 				sb.OrderBy = append(sb.OrderBy, ob)
 			}
 		}
 	}
 
+	switch {
+	case r.URL.Query().Has("onlyDeleted"):
+		d.AddDeletedFilter(sb, OnlyDeleted)
+	case r.URL.Query().Has("withDeleted"):
+		d.AddDeletedFilter(sb, WithDeleted)
+	default:
+		d.AddDeletedFilter(sb, ExcludeDeleted)
+	}
+
+	if s := r.URL.Query().Get("asOf"); s != "" {
+		asOf, err := ParseAsOf(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = d.AddAsOf(sb, asOf)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	sb.Limit, err = GetQueryUint("limit", 1000)
 	if err != nil {
 		return nil, nil, err
@@ -95,4 +122,4 @@ func (d *Database) SelectBuilderFromRequest(r *http.Request, withKey bool) (*Sel
 }
 
 // https://regex101.com/r/9n82vv/1
-var regOrderBy = regexp.MustCompile(`(?i)(-?)(\w+) *(?:(asc|desc|))`)
+var regOrderBy = regexp.MustCompile(`(?i)(-?)(\w+(?:\.\w+|\[\d+\])*) *(?:(asc|desc|))`)
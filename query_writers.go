@@ -4,9 +4,13 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"net/http"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // queryJsonArrayWriter runs the query and streams the result as a json array of arrays
@@ -24,6 +28,11 @@ func (d *Database) QueryJsonArrayWriter(w io.Writer, query string, args []interf
 	}
 	defer rows.Close()
 
+	types, err := declTypes(rows)
+	if err != nil {
+		return err
+	}
+
 	w.Write([]byte("["))
 	addComma := false // we prefix with a comma when it's not the first row
 
@@ -32,6 +41,7 @@ func (d *Database) QueryJsonArrayWriter(w io.Writer, query string, args []interf
 		if err != nil {
 			return err
 		}
+		typeSlice(types, ret)
 
 		if addComma {
 			w.Write([]byte(","))
@@ -63,6 +73,11 @@ func (d *Database) QueryJsonWriter(w io.Writer, query string, args []interface{}
 	}
 	defer rows.Close()
 
+	types, err := columnTypes(rows)
+	if err != nil {
+		return err
+	}
+
 	w.Write([]byte("["))
 	addComma := false // we prefix with a comma when it's not the first row
 
@@ -72,6 +87,7 @@ func (d *Database) QueryJsonWriter(w io.Writer, query string, args []interface{}
 		if err != nil {
 			return err
 		}
+		typeMap(types, ret)
 
 		if addComma {
 			w.Write([]byte(","))
@@ -91,6 +107,16 @@ func (d *Database) QueryJsonWriter(w io.Writer, query string, args []interface{}
 
 // queryCsvWriter runs the query and streams the result as csv to the given Writer
 func (d *Database) QueryCsvWriter(w io.Writer, query string, args []interface{}) error {
+	return d.queryDelimitedWriter(w, ',', query, args)
+}
+
+// QueryTsvWriter runs the query and streams the result as tab separated values to the
+// given Writer
+func (d *Database) QueryTsvWriter(w io.Writer, query string, args []interface{}) error {
+	return d.queryDelimitedWriter(w, '\t', query, args)
+}
+
+func (d *Database) queryDelimitedWriter(w io.Writer, comma rune, query string, args []interface{}) error {
 	tx, err := d.DB.Beginx()
 	if err != nil {
 		return err
@@ -109,6 +135,7 @@ func (d *Database) QueryCsvWriter(w io.Writer, query string, args []interface{})
 	}
 
 	csv := csv.NewWriter(w)
+	csv.Comma = comma
 	err = csv.Write(cols)
 	if err != nil {
 		return err
@@ -140,6 +167,151 @@ func (d *Database) QueryCsvWriter(w io.Writer, query string, args []interface{})
 	return nil
 }
 
+// NdjsonFlushRows is the number of rows QueryNdjsonWriter writes between flushes
+var NdjsonFlushRows = 100
+
+// NdjsonFlushInterval is the longest QueryNdjsonWriter waits between flushes
+var NdjsonFlushInterval = time.Second
+
+// QueryNdjsonWriter runs the query and streams the result as newline delimited json, one
+// object per row, to the given Writer. If the Writer is a http.Flusher it is flushed
+// every NdjsonFlushRows rows or NdjsonFlushInterval, so clients can consume the rows as
+// they arrive.
+func (d *Database) QueryNdjsonWriter(w io.Writer, query string, args []interface{}) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // This is a query so we always rollback
+
+	rows, err := tx.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	types, err := columnTypes(rows)
+	if err != nil {
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+	unflushed := 0
+	lastFlush := time.Now()
+
+	for rows.Next() {
+		ret := make(map[string]interface{})
+		err := rows.MapScan(ret)
+		if err != nil {
+			return err
+		}
+
+		typeMap(types, ret)
+
+		b, err := json.Marshal(ret)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		if err != nil {
+			return err
+		}
+
+		unflushed++
+		if flusher != nil && (unflushed >= NdjsonFlushRows || time.Since(lastFlush) >= NdjsonFlushInterval) {
+			flusher.Flush()
+			unflushed = 0
+			lastFlush = time.Now()
+		}
+	}
+	if flusher != nil && unflushed > 0 {
+		flusher.Flush()
+	}
+
+	return rows.Err()
+}
+
+var xmlNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// QueryXmlWriter runs the query and streams the result as xml to the given Writer, as a
+// <rows> element with a <row> element per row. Each column is an element of the same
+// name, or a <field name="..."> element if the name is not a valid element name. Null
+// values are left out.
+func (d *Database) QueryXmlWriter(w io.Writer, query string, args []interface{}) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // This is a query so we always rollback
+
+	rows, err := tx.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	elements := make([]xml.StartElement, len(cols))
+	for i, c := range cols {
+		if xmlNameRegexp.MatchString(c) && !strings.HasPrefix(strings.ToLower(c), "xml") {
+			elements[i] = xml.StartElement{Name: xml.Name{Local: c}}
+		} else {
+			elements[i] = xml.StartElement{
+				Name: xml.Name{Local: "field"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: c}},
+			}
+		}
+	}
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	rowsElement := xml.StartElement{Name: xml.Name{Local: "rows"}}
+	rowElement := xml.StartElement{Name: xml.Name{Local: "row"}}
+	err = enc.EncodeToken(rowsElement)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		ret := make(map[string]interface{})
+		err := rows.MapScan(ret)
+		if err != nil {
+			return err
+		}
+
+		err = enc.EncodeToken(rowElement)
+		if err != nil {
+			return err
+		}
+		for i, c := range cols {
+			v, ok := ret[c]
+			if !ok || v == nil {
+				continue
+			}
+			err = enc.EncodeElement(fmt.Sprintf("%v", v), elements[i])
+			if err != nil {
+				return err
+			}
+		}
+		err = enc.EncodeToken(rowElement.End())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = enc.EncodeToken(rowsElement.End())
+	if err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
 // queryJsonWriter runs the query and streams the result as json to the given Writer
 func (d *Database) queryJsonWriterRow(w io.Writer, sb *SelectBuilder, args []interface{}) error {
 	tx, err := d.DB.Beginx()
@@ -149,6 +321,7 @@ func (d *Database) queryJsonWriterRow(w io.Writer, sb *SelectBuilder, args []int
 	defer tx.Rollback() // This is a query so we always rollback
 
 	d.AddRefLabels(sb, "")
+	d.AddDeletedFilter(sb, ExcludeDeleted)
 
 	query, err := sb.ToSql()
 	if err != nil {
@@ -162,17 +335,23 @@ func (d *Database) queryJsonWriterRow(w io.Writer, sb *SelectBuilder, args []int
 		return sql.ErrNoRows
 	}
 
+	types, err := columnTypes(row)
+	if err != nil {
+		return err
+	}
+
 	ret := make(map[string]interface{})
 
 	err = row.MapScan(ret)
 	if err != nil {
 		return err
 	}
+	typeMap(types, ret)
 
 	// For each select field, check to see if it is referenced to from other tables
 	d.debugLog.Printf("Select: %s\n", sb.Select)
-	d.debugLog.Printf("Config: %s\n", d.config.String())
-	for _, table2 := range d.config.Tables {
+	d.debugLog.Printf("Config: %s\n", d.getConfig().String())
+	for _, table2 := range d.getConfig().Tables {
 		// d.debugLog.Printf("AAAAAAAAAAAAAAAAA. table: %s, field: %s, table2: %s\n", table, field, table2.Name)
 		for _, field2 := range table2.Fields {
 			if field2.References != "" {
@@ -197,6 +376,10 @@ func (d *Database) queryJsonWriterRow(w io.Writer, sb *SelectBuilder, args []int
 						if err != nil {
 							return fmt.Errorf("sub-query '%s': %w", table2.Name, err)
 						}
+						subTypes, err := columnTypes(rows)
+						if err != nil {
+							return fmt.Errorf("sub-query '%s': %w", table2.Name, err)
+						}
 
 						subRet := make([]map[string]interface{}, 0)
 						for rows.Next() {
@@ -207,6 +390,7 @@ func (d *Database) queryJsonWriterRow(w io.Writer, sb *SelectBuilder, args []int
 							if err != nil {
 								return fmt.Errorf("sub-query '%s': %w", table2.Name, err)
 							}
+							typeMap(subTypes, m)
 							subRet = append(subRet, m)
 							// d.debugLog.Printf("F: sub-query: add %v\n", m)
 						}
@@ -230,33 +414,11 @@ func (d *Database) queryJsonWriterRow(w io.Writer, sb *SelectBuilder, args []int
 	return nil
 }
 
-func processFields_JSON(m map[string]interface{}) {
-	for k, v := range m {
-		if strings.HasSuffix(k, "_JSON") {
-			switch q := v.(type) {
-			case nil:
-				delete(m, k)
-
-			case string:
-				var x interface{}
-				err := json.Unmarshal([]byte(q), &x)
-				if err == nil {
-					delete(m, k)
-					if x != nil {
-						newK := strings.TrimSuffix(k, "_JSON")
-						m[newK] = x
-					}
-				}
-			}
-		}
-	}
-}
-
 // AddRefLabels adds *_RefLabel select fields and joins for all existing select fields that have
 // a reference (with a label field)
 func (d *Database) AddRefLabels(sb *SelectBuilder, exclTable string) {
 	// d.debugLog.Printf("AddRefLabels: sb: %#v\n", sb)
-	if ct := d.config.GetTable(sb.From); ct != nil {
+	if ct := d.getConfig().GetTable(sb.From); ct != nil {
 		if len(sb.Select) == 0 {
 			for _, f := range ct.Fields {
 				sb.Select = append(sb.Select, tableFieldWrapped(ct.Name, f.Name))
@@ -0,0 +1,158 @@
+package sqliteapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"gopkg.in/yaml.v2"
+)
+
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Restore replaces the database with the backup at path, which must pass PRAGMA
+// integrity_check and have a stored config. The stored config is reloaded, unless the
+// backup is older than the running config in which case it is migrated to the running
+// config. Writes wait until the restore has finished.
+func (d *Database) Restore(path string) error {
+	version, err := checkRestoreFile(path)
+	if err != nil {
+		return err
+	}
+
+	d.writes.Lock()
+	defer d.writes.Unlock()
+
+	running := d.getConfig()
+	var runningVersion int
+	err = d.DB.Get(&runningVersion, "PRAGMA user_version")
+	if err != nil {
+		return err
+	}
+
+	err = d.restoreFrom(path)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	err = d.Refresh()
+	if err != nil {
+		return err
+	}
+
+	if running != nil && version < runningVersion {
+		err = d.ApplyConfig(running, &ConfigOptions{RetainUnmanaged: true})
+		if err != nil {
+			return fmt.Errorf("restore: migrating from version %d: %w", version, err)
+		}
+		d.log.Printf("Restored database version %d, migrated to version %d", version, runningVersion)
+	} else {
+		c, err := d.storedConfig()
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		d.setConfig(c)
+		d.log.Printf("Restored database version %d", version)
+	}
+
+	return d.Refresh()
+}
+
+// checkRestoreFile checks the file is a healthy database with a stored config,
+// returning its version
+func checkRestoreFile(path string) (int, error) {
+	err := checkBackupIntegrity(path)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+	}
+
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var n int
+	err = db.Get(&n, `SELECT COUNT(*) FROM sqlite_master WHERE type="table" AND name="gdb_config"`)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: missing gdb_config", ErrInvalidBackup)
+	}
+
+	var version int
+	err = db.Get(&version, "PRAGMA user_version")
+	return version, err
+}
+
+// restoreFrom copies the database at path over the live database using the backup API
+func (d *Database) restoreFrom(path string) error {
+	srcDB, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	srcConn, err := srcDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstConn, err := d.DB.DB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	var srcSQLiteConn *sqlite3.SQLiteConn
+
+	bf := func(driverConn interface{}) error {
+		dstSQLiteConn := driverConn.(*sqlite3.SQLiteConn)
+		return copyDatabaseConnection(context.Background(), dstSQLiteConn, srcSQLiteConn, &BackupOptions{})
+	}
+
+	return srcConn.Raw(
+		func(driverConn interface{}) error {
+			srcSQLiteConn = driverConn.(*sqlite3.SQLiteConn)
+			return dstConn.Raw(bf)
+		})
+}
+
+// storedConfig returns the latest config stored in the database, or nil if there is none
+func (d *Database) storedConfig() (*Config, error) {
+	var b []byte
+	err := d.DB.Get(&b, "SELECT config FROM gdb_config ORDER BY id DESC LIMIT 1")
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return decodeStoredConfig(b)
+}
+
+// decodeStoredConfig decodes a config as stored in gdb_config, which unlike
+// NewConfigFromYaml is the marshalled Config
+func decodeStoredConfig(b []byte) (*Config, error) {
+	c := &Config{}
+	err := yaml.Unmarshal(b, c)
+	if err != nil {
+		return nil, fmt.Errorf("stored config: %w", err)
+	}
+	for ti, t := range c.Tables {
+		for fi, f := range t.Fields {
+			if f.Regex != "" {
+				c.Tables[ti].Fields[fi].regexp, err = regexp.Compile(f.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("stored config: %s.%s: Regexp error: %w", t.Name, f.Name, err)
+				}
+			}
+		}
+	}
+	return c, nil
+}
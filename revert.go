@@ -0,0 +1,173 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrUnknownAuditEntry = errors.New("unknown audit entry")
+
+// RevertRow updates the row back to how it was after the change recorded by the audit
+// entry auditId, along with its xxx_RefTable rows as they were before the row's next
+// change. The update is made via UpdateMap so validation and hooks run, and is itself
+// recorded in the audit trail as a revert. Requires the Audit() option.
+func (d *Database) RevertRow(table string, key interface{}, auditId int64, user User) error {
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return ErrUnknownTable
+	}
+	if !d.isAudited(table) {
+		return errors.New("table is not audited")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	data, err := d.revertDataWithTx(tx, table, key, auditId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	key, err = d.updateMapWithTxAudit(tx, table, data, user, AuditRevert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return err
+	}
+
+	err = d.runAfterHooks(table, HookParams{table, key, data, HookAfterUpdate, tx, user})
+	if err != nil {
+		d.log.Printf("error running after revert hook: %s", err)
+		return err
+	}
+
+	d.log.Printf("%s: Reverted row where %s = '%v' to audit entry %d", table, strings.Join(tableInfo.GetPrimaryKeyFields(), ","), key, auditId)
+	return nil
+}
+
+// revertDataWithTx returns the data to pass to UpdateMap to revert the row to the
+// audit entry
+func (d *Database) revertDataWithTx(tx *sqlx.Tx, table string, key interface{}, auditId int64) (map[string]interface{}, error) {
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	keyValues, err := tableInfo.KeyValues(key)
+	if err != nil {
+		return nil, err
+	}
+	rowKey := keyString(keyValues)
+
+	rows, err := tx.Queryx("SELECT * FROM gdb_audit WHERE id=? AND tableName=? AND rowKey=?", auditId, table, rowKey)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanAuditEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrUnknownAuditEntry
+	}
+	if entries[0].After == nil {
+		return nil, errors.New("cannot revert to a deleted row")
+	}
+
+	data, err := decodeJsonRow(entries[0].After)
+	if err != nil {
+		return nil, err
+	}
+	for _, pk := range tableInfo.GetPrimaryKeyFields() {
+		if _, ok := data[pk]; !ok { // Not recorded e.g. hidden
+			tableInfo.SetKey(data, keyValues)
+			break
+		}
+	}
+
+	// Children are taken as they were before the row's next change, as a change to the
+	// row records the row first followed by any changes to its xxx_RefTable rows
+	var next int64
+	err = tx.Get(&next, "SELECT IFNULL(MIN(id), 0) FROM gdb_audit WHERE tableName=? AND rowKey=? AND id>?", table, rowKey, auditId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range d.getConfig().GetBackReferences(table) {
+		if !d.isAudited(ref.SourceTable) {
+			continue // Leave the rows as they are
+		}
+		parentKey, ok := data[ref.KeyField]
+		if !ok {
+			continue
+		}
+
+		// The latest entry of each child row, where it then referenced the parent
+		q := "SELECT a.* FROM gdb_audit a WHERE a.tableName=? AND CAST(json_extract(a.after, ?) AS TEXT)=?"
+		q += " AND a.id=(SELECT MAX(b.id) FROM gdb_audit b WHERE b.tableName=a.tableName AND b.rowKey=a.rowKey"
+		args := []interface{}{ref.SourceTable, `$."` + ref.SourceField + `"`, fmt.Sprintf("%v", parentKey)}
+		if next > 0 {
+			q += " AND b.id<?"
+			args = append(args, next)
+		}
+		q += ") ORDER BY a.id"
+		rows, err := tx.Queryx(q, args...)
+		if err != nil {
+			return nil, err
+		}
+		children, err := scanAuditEntries(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		refRows := make([]map[string]interface{}, 0)
+		for _, child := range children {
+			m, err := decodeJsonRow(child.After)
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := m[DeletedAtField]; ok && v != nil {
+				continue
+			}
+			refRows = append(refRows, m)
+		}
+		data[ref.SourceTable+RefTableSuffix] = refRows
+	}
+
+	return data, nil
+}
+
+// decodeJsonRow decodes a json encoded row, such as those recorded in the audit table,
+// with whole numbers returned as int64's
+func decodeJsonRow(b []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	m := make(map[string]interface{})
+	err := dec.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				m[k] = i
+			} else if f, err := n.Float64(); err == nil {
+				m[k] = f
+			}
+		}
+	}
+	return m, nil
+}
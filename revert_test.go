@@ -0,0 +1,115 @@
+package sqliteapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevertRow(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    id:
+    customer:
+      min: 3
+  invoiceItem:
+    id:
+    invoiceId:
+      type: integer
+      ref: invoice.id/customer
+    item:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		Audit(),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	hookCalls := 0
+	db.AddHook("invoice", func(p HookParams) error {
+		if p.Action == HookBeforeUpdate {
+			hookCalls++
+		}
+		return nil
+	})
+
+	inv := map[string]interface{}{
+		"customer": "Fred Blogs",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item A"},
+			{"item": "Item B"},
+		},
+	}
+	id, err := db.InsertMap("invoice", inv, nil)
+	assert.NoError(t, err)
+	// Items of other invoices are left out
+	_, err = db.InsertMap("invoice", map[string]interface{}{
+		"customer": "Jane Doe",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item X"},
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.UpdateMap("invoice", map[string]interface{}{
+		"id":       id,
+		"customer": "ACME",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item C"},
+		},
+	}, nil))
+
+	history, err := db.History("invoice", id)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	assert.NoError(t, db.RevertRow("invoice", id, history[0].ID, testUser("fred")))
+	assert.Equal(t, 2, hookCalls)
+
+	m, err := db.GetMap("invoice", id, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "Fred Blogs", m["customer"])
+	items := []string{}
+	for _, item := range m["invoiceItem_RefTable"].([]map[string]interface{}) {
+		items = append(items, item["item"].(string))
+	}
+	assert.ElementsMatch(t, []string{"Item A", "Item B"}, items)
+
+	// The revert is recorded
+	history, err = db.History("invoice", id)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, AuditRevert, history[2].Action)
+		assert.Equal(t, "fred", history[2].Username)
+	}
+
+	// Entry for another row
+	_, err = db.InsertMap("invoice", map[string]interface{}{"customer": "Other"}, nil)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, db.RevertRow("invoice", 2, history[0].ID, nil), ErrUnknownAuditEntry)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+fmt.Sprintf("/invoice/%d?revert=%d", id, history[1].ID), "", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	m, err = db.GetMap("invoice", id, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACME", m["customer"])
+	assert.Len(t, m["invoiceItem_RefTable"], 1)
+
+	res, err = http.Post(ts.URL+fmt.Sprintf("/invoice/%d?revert=999", id), "", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
@@ -0,0 +1,275 @@
+package sqliteapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v2"
+)
+
+var ErrSeedProtected = errors.New("seeded row is protected")
+
+// ConfigSeed is the fixed rows of a table, such as a lookup table, which are inserted or
+// updated by ApplyConfig
+type ConfigSeed struct {
+	Version int                      `yaml:"version,omitempty"` // Rows are only applied when changed
+	Key     []string                 `yaml:"key,omitempty"`     // Natural key, defaults to the primary key
+	Remove  bool                     `yaml:"remove,omitempty"`  // Delete seeded rows removed from the config
+	Protect bool                     `yaml:"protect,omitempty"` // Prevent seeded rows being deleted
+	Rows    []map[string]interface{} `yaml:"rows"`
+}
+
+// setSeed sets the seed from the yaml config, being a map of the seed options and rows
+// or just a list of rows
+func (table *ConfigTable) setSeed(value interface{}) error {
+	seed := &ConfigSeed{}
+	rows := value
+	if _, isMap := value.(yaml.MapSlice); isMap {
+		rows = nil
+		err := forEachMapSlice(value, func(name string, v interface{}) error {
+			var err error
+			switch name {
+			case "version":
+				var i int64
+				i, err = toInt(v)
+				seed.Version = int(i)
+			case "key":
+				switch x := v.(type) {
+				case string:
+					seed.Key = strings.Split(x, ",")
+				case []interface{}:
+					for _, k := range x {
+						seed.Key = append(seed.Key, fmt.Sprint(k))
+					}
+				default:
+					err = fmt.Errorf("key: expected field name(s), got %T", v)
+				}
+			case "remove":
+				seed.Remove, err = toBool(v)
+			case "protect":
+				seed.Protect, err = toBool(v)
+			case "rows":
+				rows = v
+			default:
+				err = fmt.Errorf("unknown option '%s'", name)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	list, ok := rows.([]interface{})
+	if !ok {
+		return fmt.Errorf("expected a list of rows, got %T", rows)
+	}
+	for i, r := range list {
+		row := make(map[string]interface{})
+		err := forEachMapSlice(r, func(name string, v interface{}) error {
+			if !table.HasField(name) {
+				return fmt.Errorf("unknown field '%s'", name)
+			}
+			row[name] = v
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i+1, err)
+		}
+		seed.Rows = append(seed.Rows, row)
+	}
+	table.Seed = seed
+
+	key := table.seedKey()
+	if len(key) == 0 {
+		return errors.New("table has no primary key, set the seed key")
+	}
+	for _, k := range key {
+		if !table.HasField(k) {
+			return fmt.Errorf("unknown key field '%s'", k)
+		}
+	}
+	for i, row := range seed.Rows {
+		for _, k := range key {
+			if _, ok := row[k]; !ok {
+				return fmt.Errorf("row %d: missing key field '%s'", i+1, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (table *ConfigTable) seedKey() []string {
+	if table.Seed != nil && len(table.Seed.Key) > 0 {
+		return table.Seed.Key
+	}
+	return table.PrimaryKeys()
+}
+
+// seedValue returns the value as stored
+func seedValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return int64(1)
+		}
+		return int64(0)
+	case []byte:
+		return string(x)
+	}
+	return v
+}
+
+func seedEqual(a, b interface{}) bool {
+	return fmt.Sprint(seedValue(a)) == fmt.Sprint(seedValue(b))
+}
+
+// hasRow returns true if the seed has a row with the key values of row
+func (seed *ConfigSeed) hasRow(key []string, row map[string]interface{}) bool {
+rows:
+	for _, r := range seed.Rows {
+		for _, k := range key {
+			if !seedEqual(r[k], row[k]) {
+				continue rows
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func seedKeyWhere(key []string) string {
+	return "`" + strings.Join(key, "`=? AND `") + "`=?"
+}
+
+func seedKeyValues(key []string, row map[string]interface{}) []interface{} {
+	ret := make([]interface{}, len(key))
+	for i, k := range key {
+		ret[i] = seedValue(row[k])
+	}
+	return ret
+}
+
+// applySeedsWithTx inserts or updates the seed rows of the tables, only writing rows
+// that differ, and deletes the rows removed from the seeds when enabled. Seeds with a
+// version are skipped if the version was applied by the previous config.
+func (d *Database) applySeedsWithTx(tx *sqlx.Tx, c *Config) error {
+	var prev *Config
+	var b []byte
+	err := tx.Get(&b, "SELECT config FROM gdb_config ORDER BY id DESC LIMIT 1")
+	if err == nil {
+		prev, err = decodeStoredConfig(b)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("seed: previous config: %w", err)
+	}
+
+	for _, table := range c.Tables {
+		seed := table.Seed
+		if seed == nil {
+			continue
+		}
+		var prevSeed *ConfigSeed
+		if pt := prev.GetTable(table.Name); pt != nil {
+			prevSeed = pt.Seed
+		}
+		if seed.Version != 0 && prevSeed != nil && prevSeed.Version == seed.Version {
+			continue
+		}
+
+		key := table.seedKey()
+		where := seedKeyWhere(key)
+		inserted, updated, removed := 0, 0, 0
+
+		for _, row := range seed.Rows {
+			keyValues := seedKeyValues(key, row)
+			existing := make(map[string]interface{})
+			err = tx.QueryRowx("SELECT * FROM `"+table.Name+"` WHERE "+where, keyValues...).MapScan(existing)
+			if errors.Is(err, sql.ErrNoRows) {
+				fields := []string{}
+				values := []interface{}{}
+				for k, v := range row {
+					fields = append(fields, k)
+					values = append(values, seedValue(v))
+				}
+				_, err = tx.Exec("INSERT INTO `"+table.Name+"` (`"+strings.Join(fields, "`,`")+"`) VALUES (?"+
+					strings.Repeat(",?", len(fields)-1)+")", values...)
+				if err != nil {
+					return fmt.Errorf("seed %s: %w", table.Name, err)
+				}
+				inserted++
+				continue
+			} else if err != nil {
+				return fmt.Errorf("seed %s: %w", table.Name, err)
+			}
+
+			fields := []string{}
+			values := []interface{}{}
+			for k, v := range row {
+				if !seedEqual(existing[k], v) {
+					fields = append(fields, k)
+					values = append(values, seedValue(v))
+				}
+			}
+			if len(fields) > 0 {
+				_, err = tx.Exec("UPDATE `"+table.Name+"` SET `"+strings.Join(fields, "`=?,`")+"`=? WHERE "+where,
+					append(values, keyValues...)...)
+				if err != nil {
+					return fmt.Errorf("seed %s: %w", table.Name, err)
+				}
+				updated++
+			}
+		}
+
+		if seed.Remove && prevSeed != nil {
+			for _, row := range prevSeed.Rows {
+				if seed.hasRow(key, row) {
+					continue
+				}
+				keyValues := seedKeyValues(key, row)
+				existing := make(map[string]interface{})
+				err = tx.QueryRowx("SELECT * FROM `"+table.Name+"` WHERE "+where, keyValues...).MapScan(existing)
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				} else if err != nil {
+					return fmt.Errorf("seed %s: %w", table.Name, err)
+				}
+
+				// Foreign keys are off while the config is applied
+				for _, ref := range c.GetBackReferences(table.Name) {
+					var n int
+					err = tx.Get(&n, "SELECT COUNT(*) FROM `"+ref.SourceTable+"` WHERE `"+ref.SourceField+"`=?", existing[ref.KeyField])
+					if err != nil {
+						return fmt.Errorf("seed %s: %w", table.Name, err)
+					}
+					if n > 0 {
+						return fmt.Errorf("seed %s: unable to remove %v, referenced by %s", table.Name, keyValues, ref.SourceTable)
+					}
+				}
+
+				_, err = tx.Exec("DELETE FROM `"+table.Name+"` WHERE "+where, keyValues...)
+				if err != nil {
+					return fmt.Errorf("seed %s: %w", table.Name, err)
+				}
+				removed++
+			}
+		}
+
+		if inserted+updated+removed > 0 {
+			d.log.Printf("Seeded %s: %d inserted, %d updated, %d removed", table.Name, inserted, updated, removed)
+		}
+	}
+	return nil
+}
+
+// isProtectedSeedRow returns true if the row is a seeded row protected from deletion
+func (d *Database) isProtectedSeedRow(table string, row map[string]interface{}) bool {
+	ct := d.getConfig().GetTable(table)
+	if ct == nil || ct.Seed == nil || !ct.Seed.Protect {
+		return false
+	}
+	return ct.Seed.hasRow(ct.seedKey(), row)
+}
@@ -0,0 +1,156 @@
+package sqliteapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeed(t *testing.T) {
+	config := func(version string, rows string) []byte {
+		return []byte(`
+tables:
+  status:
+    id:
+    name:
+      unique: true
+    active:
+      type: boolean
+    seed:
+      key: name
+      remove: true
+      protect: true` + version + `
+      rows:` + rows + `
+  job:
+    id:
+    statusId:
+      type: integer
+      ref: status.id/name
+`)
+	}
+	v1 := config("", `
+        - {name: Open, active: true}
+        - {name: Closed, active: false}`)
+
+	file := filepath.Join(t.TempDir(), "test.db")
+	open := func(c []byte) (*Database, error) {
+		return NewDatabase(file, YamlConfig(c))
+	}
+	statuses := func(db *Database) map[string]bool {
+		rows := []struct {
+			Name   string
+			Active bool
+		}{}
+		assert.NoError(t, db.DB.Select(&rows, "SELECT name, active FROM status"))
+		ret := make(map[string]bool)
+		for _, r := range rows {
+			ret[r.Name] = r.Active
+		}
+		return ret
+	}
+
+	db, err := open(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"Open": true, "Closed": false}, statuses(db))
+
+	// Seeded rows are protected, others are not
+	var closedID int64
+	assert.NoError(t, db.DB.Get(&closedID, "SELECT id FROM status WHERE name='Closed'"))
+	assert.ErrorIs(t, db.Delete("status", closedID, nil), ErrSeedProtected)
+	other, err := db.InsertMap("status", map[string]interface{}{"name": "Other"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Delete("status", other, nil))
+
+	ts := httptest.NewServer(db.Handler(""))
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/status/1", nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	ts.Close()
+
+	// Changed rows are put back when reapplied
+	_, err = db.DB.Exec("UPDATE status SET active=0 WHERE name='Open'")
+	assert.NoError(t, err)
+	db.Close()
+	db, err = open(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"Open": true, "Closed": false}, statuses(db))
+	var version int
+	assert.NoError(t, db.DB.Get(&version, "PRAGMA user_version"))
+	assert.Equal(t, 1, version)
+	db.Close()
+
+	// Removed rows are deleted, and the new seed stored
+	v2 := config("", `
+        - {name: Open, active: true}
+        - {name: Pending, active: true}`)
+	db, err = open(v2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"Open": true, "Pending": true}, statuses(db))
+	c, err := db.storedConfig()
+	assert.NoError(t, err)
+	assert.Len(t, c.GetTable("status").Seed.Rows, 2)
+
+	// Referenced rows are not removed
+	var pendingID int64
+	assert.NoError(t, db.DB.Get(&pendingID, "SELECT id FROM status WHERE name='Pending'"))
+	_, err = db.InsertMap("job", map[string]interface{}{"statusId": pendingID}, nil)
+	assert.NoError(t, err)
+	db.Close()
+	_, err = open(v1)
+	assert.ErrorContains(t, err, "referenced by job")
+
+	// Versioned seeds are only applied when the version changes
+	v3 := config(`
+      version: 1`, `
+        - {name: Open, active: true}
+        - {name: Pending, active: true}`)
+	db, err = open(v3)
+	assert.NoError(t, err)
+	_, err = db.DB.Exec("UPDATE status SET active=0 WHERE name='Open'")
+	assert.NoError(t, err)
+	db.Close()
+	db, err = open(v3)
+	assert.NoError(t, err)
+	assert.False(t, statuses(db)["Open"])
+	db.Close()
+	db, err = open([]byte(strings.Replace(string(v3), "version: 1", "version: 2", 1)))
+	assert.NoError(t, err)
+	assert.True(t, statuses(db)["Open"])
+	db.Close()
+}
+
+func TestSeedConfigErrors(t *testing.T) {
+	for yaml, expected := range map[string]string{
+		`
+tables:
+  status:
+    id:
+    name:
+    seed:
+      - {id: 1, nme: Open}`: "status.seed: row 1: unknown field 'nme'",
+		`
+tables:
+  status:
+    id:
+    name:
+    seed:
+      - {name: Open}`: "status.seed: row 1: missing key field 'id'",
+		`
+tables:
+  status:
+    id:
+    name:
+    seed:
+      key: title
+      rows: []`: "status.seed: unknown key field 'title'",
+	} {
+		_, err := NewConfigFromYaml([]byte(yaml))
+		assert.EqualError(t, err, expected)
+	}
+}
@@ -38,6 +38,9 @@ type SelectBuilder struct {
 
 	// Offset default 0
 	Offset uint
+
+	// Sources are queries to select from in place of tables, keyed by table name
+	Sources map[string]string
 }
 
 type Join struct {
@@ -56,6 +59,7 @@ type JoinType string
 type OrderBy struct {
 	Field     string
 	Ascending bool
+	IsExpr    bool // Field is an sql expression, used as is
 }
 
 const (
@@ -93,12 +97,12 @@ func (sb *SelectBuilder) ToSql() (string, error) {
 	}
 
 	// FROM
-	s += "\nFROM `" + sb.From + "`"
+	s += "\nFROM " + sb.source(sb.From)
 
 	// JOINS
 	joins := make([]string, 0)
 	for _, j := range sb.Joins {
-		tmp := "\n" + string(j.Type) + " JOIN `" + j.Table + "` ON "
+		tmp := "\n" + string(j.Type) + " JOIN " + sb.source(j.Table) + " ON "
 		for i, on := range j.On {
 			if i > 0 {
 				tmp += " AND "
@@ -137,7 +141,11 @@ func (sb *SelectBuilder) ToSql() (string, error) {
 			if i > 0 {
 				s += ", "
 			}
-			s += tableFieldWrapped(sb.From, ob.Field)
+			if ob.IsExpr {
+				s += ob.Field
+			} else {
+				s += tableFieldWrapped(sb.From, ob.Field)
+			}
 			if ob.Ascending {
 				s += " ASC"
 			} else {
@@ -158,6 +166,14 @@ func (sb *SelectBuilder) ToSql() (string, error) {
 	return s, nil
 }
 
+// source returns the table, or the query to use in its place aliased as the table
+func (sb *SelectBuilder) source(table string) string {
+	if q, ok := sb.Sources[table]; ok {
+		return "(" + q + ") AS `" + table + "`"
+	}
+	return "`" + table + "`"
+}
+
 func tableFieldWrapped(table string, field string) string {
 	if table == "" {
 		return fmt.Sprintf("`%s`", field)
@@ -52,7 +52,17 @@ func (s muxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case 0:
 			d.HandleGetTableNames(w, r)
 		case 1:
-			d.HandleGetRows(w, r)
+			if parts[0] == "_events" {
+				d.HandleGetEvents(w, r)
+			} else if parts[0] == "_webhooks" {
+				d.HandleGetWebhooks(w, r)
+			} else if parts[0] == "_changes" {
+				d.HandleGetChanges(w, r)
+			} else if parts[0] == "_backup" {
+				d.HandleGetBackup(w, r)
+			} else {
+				d.HandleGetRows(w, r)
+			}
 		case 2:
 			d.HandleGetRow(w, r)
 		default:
@@ -64,10 +74,22 @@ func (s muxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case 0:
 			d.HandlePostSQL(w, r)
 		case 1:
-			d.HandlePostTable(w, r)
+			if parts[0] == "_batch" {
+				d.HandlePostBatch(w, r)
+			} else if parts[0] == "_restore" {
+				d.HandlePostRestore(w, r)
+			} else {
+				d.HandlePostTable(w, r)
+			}
 		case 2:
 			if parts[0] == "_" {
 				d.HandlePostFunction(w, r)
+			} else if parts[0] == "_webhooks" && r.URL.Query().Has("redeliver") {
+				d.HandleRedeliverWebhook(w, r)
+			} else if r.URL.Query().Has("restore") {
+				d.HandleRestoreRow(w, r)
+			} else if r.URL.Query().Has("revert") {
+				d.HandleRevertRow(w, r)
 			} else {
 				http.Error(w, "too many path elements", http.StatusBadRequest)
 			}
@@ -0,0 +1,255 @@
+package sqliteapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DeletedAtField is the special field set when a row of a softDelete table is deleted
+const DeletedAtField = "deletedAt"
+
+// DeletedMode controls if soft deleted rows are included when reading
+type DeletedMode int
+
+const (
+	ExcludeDeleted = DeletedMode(iota) // Default
+	WithDeleted
+	OnlyDeleted
+)
+
+var ErrNotDeleted = errors.New("row is not deleted")
+
+// deletedAtFormat matches CURRENT_TIMESTAMP but with microseconds, so rows deleted
+// together can be told apart from rows deleted separately
+const deletedAtFormat = "2006-01-02 15:04:05.000000"
+
+func (d *Database) isSoftDelete(table string) bool {
+	ct := d.getConfig().GetTable(table)
+	if ct == nil || !ct.SoftDelete {
+		return false
+	}
+	ti := d.dbInfo.GetTableInfo(table)
+	return ti != nil && ti.HasField(DeletedAtField)
+}
+
+// AddDeletedFilter adds a where condition to exclude (or only include) soft deleted
+// rows, if the table is a softDelete table
+func (d *Database) AddDeletedFilter(sb *SelectBuilder, mode DeletedMode) {
+	if !d.isSoftDelete(sb.From) {
+		return
+	}
+	switch mode {
+	case ExcludeDeleted:
+		sb.Where = append(sb.Where, IsNull(sb.From, DeletedAtField))
+	case OnlyDeleted:
+		sb.Where = append(sb.Where, NotNull(sb.From, DeletedAtField))
+	}
+}
+
+// softDeleteWithTx sets deletedAt on the row and the rows referencing it
+func (d *Database) softDeleteWithTx(tx *sqlx.Tx, table string, key interface{}, data map[string]interface{}, user User) error {
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return ErrUnknownTable
+	}
+
+	keyValues, err := tableInfo.KeyValues(key)
+	if err != nil {
+		return err
+	}
+
+	ts := time.Now().UTC().Format(deletedAtFormat)
+
+	err = d.softDeleteRefsWithTx(tx, table, data, ts, user)
+	if err != nil {
+		return err
+	}
+
+	where := tableInfo.KeyWhere() + " AND `" + DeletedAtField + "` ISNULL"
+	q := "UPDATE `" + table + "` SET `" + DeletedAtField + "`=?"
+	q += " WHERE " + where
+	d.debugLog.Printf("SQL: %s\nArgs: %v, %v\n", q, ts, keyValues)
+	err = d.auditWithTx(tx, table, AuditDelete, where, keyValues, user, func() error {
+		res, err := tx.Exec(q, append([]interface{}{ts}, keyValues...)...)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUnknownKey
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	data[DeletedAtField] = ts
+	return nil
+}
+
+// softDeleteRefsWithTx sets deletedAt to ts on the rows referencing the row, and on the
+// rows referencing them
+func (d *Database) softDeleteRefsWithTx(tx *sqlx.Tx, table string, data map[string]interface{}, ts string, user User) error {
+	for _, ref := range d.getConfig().GetBackReferences(table) {
+		skey, ok := data[ref.KeyField]
+		if !ok || !d.isSoftDelete(ref.SourceTable) {
+			continue
+		}
+		where := "`" + ref.SourceField + "`=? AND `" + DeletedAtField + "` ISNULL"
+		rows, err := d.selectRowsWithTx(tx, ref.SourceTable, where, []interface{}{skey})
+		if err != nil {
+			return err
+		}
+		q := "UPDATE `" + ref.SourceTable + "` SET `" + DeletedAtField + "`=?"
+		q += " WHERE " + where
+		d.debugLog.Printf("SQL: %s\nArgs: %v, %v\n", q, ts, skey)
+		err = d.auditWithTx(tx, ref.SourceTable, AuditDelete, where, []interface{}{skey}, user, func() error {
+			_, err := tx.Exec(q, ts, skey)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// The rows are deleted before their references, so a reference cycle ends
+		for _, row := range rows {
+			err = d.softDeleteRefsWithTx(tx, ref.SourceTable, row, ts, user)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreRow undeletes a soft deleted row, and the rows referencing it that were
+// deleted at the same time. The update hooks are run.
+func (d *Database) RestoreRow(table string, key interface{}, user User) error {
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return ErrUnknownTable
+	}
+	if !d.isSoftDelete(table) {
+		return errors.New("not a soft delete table")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	data, err := d.restoreRowWithTx(tx, table, key, user)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return err
+	}
+
+	err = d.runAfterHooks(table, HookParams{table, key, data, HookAfterUpdate, tx, user})
+	if err != nil {
+		d.log.Printf("error running after restore hook: %s", err)
+		return err
+	}
+
+	d.log.Printf("%s: Restored row where %s = '%v'", table, strings.Join(tableInfo.GetPrimaryKeyFields(), ","), key)
+	return nil
+}
+
+func (d *Database) restoreRowWithTx(tx *sqlx.Tx, table string, key interface{}, user User) (map[string]interface{}, error) {
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return nil, ErrUnknownTable
+	}
+
+	keyValues, err := tableInfo.KeyValues(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := d.getMapWithTx(tx, table, key, GetOptions{Deleted: WithDeleted})
+	if err != nil {
+		return nil, err
+	}
+	if data[DeletedAtField] == nil {
+		return nil, ErrNotDeleted
+	}
+	data[DeletedAtField] = nil
+
+	err = d.runHooks(table, HookParams{table, key, data, HookBeforeUpdate, tx, user})
+	if err != nil {
+		d.log.Printf("error running before restore hook: %s", err)
+		return nil, err
+	}
+
+	// Restore the referencing rows deleted at the same time as this row
+	var ts string
+	err = tx.Get(&ts, "SELECT CAST(`"+DeletedAtField+"` AS TEXT) FROM `"+table+"` WHERE "+tableInfo.KeyWhere(), keyValues...)
+	if err != nil {
+		return nil, err
+	}
+	err = d.restoreRefsWithTx(tx, table, data, ts, user)
+	if err != nil {
+		return nil, err
+	}
+
+	q := "UPDATE `" + table + "` SET `" + DeletedAtField + "`=NULL WHERE " + tableInfo.KeyWhere()
+	d.debugLog.Printf("SQL: %s\nArgs: %v\n", q, keyValues)
+	err = d.auditWithTx(tx, table, AuditRestore, tableInfo.KeyWhere(), keyValues, user, func() error {
+		_, err := tx.Exec(q, keyValues...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// restoreRefsWithTx undeletes the rows referencing the row that were deleted at ts, and
+// the rows referencing them
+func (d *Database) restoreRefsWithTx(tx *sqlx.Tx, table string, data map[string]interface{}, ts string, user User) error {
+	for _, ref := range d.getConfig().GetBackReferences(table) {
+		skey, ok := data[ref.KeyField]
+		if !ok || !d.isSoftDelete(ref.SourceTable) {
+			continue
+		}
+		where := "`" + ref.SourceField + "`=? AND `" + DeletedAtField + "`=?"
+		args := []interface{}{skey, ts}
+		rows, err := d.selectRowsWithTx(tx, ref.SourceTable, where, args)
+		if err != nil {
+			return err
+		}
+		q := "UPDATE `" + ref.SourceTable + "` SET `" + DeletedAtField + "`=NULL WHERE " + where
+		d.debugLog.Printf("SQL: %s\nArgs: %v\n", q, args)
+		err = d.auditWithTx(tx, ref.SourceTable, AuditRestore, where, args, user, func() error {
+			_, err := tx.Exec(q, args...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			err = d.restoreRefsWithTx(tx, ref.SourceTable, row, ts, user)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Purge permanently deletes a row, whether or not it has been soft deleted, along
+// with the rows referencing it. The delete hooks are run.
+func (d *Database) Purge(table string, key interface{}, user User) (err error) {
+	return d.delete(table, key, user, true)
+}
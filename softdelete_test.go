@@ -0,0 +1,143 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDelete(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    softDelete: true
+    id:
+    customer:
+  invoiceItem:
+    softDelete: true
+    id:
+    invoiceId:
+      type: integer
+      ref: invoice.id/customer
+    item:
+  itemNote:
+    softDelete: true
+    id:
+    invoiceItemId:
+      type: integer
+      ref: invoiceItem.id/item
+    note:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.True(t, db.dbInfo.GetTableInfo("invoiceItem").HasField(DeletedAtField))
+
+	// Referencing tables must be soft deleted too
+	_, err = NewConfigFromYaml([]byte(strings.Replace(yaml, "  itemNote:\n    softDelete: true\n", "  itemNote:\n", 1)))
+	assert.EqualError(t, err, "itemNote: must be softDelete as it references invoiceItem")
+
+	inv := map[string]interface{}{
+		"customer": "Fred Blogs",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item A"},
+			{"item": "Item B"},
+		},
+	}
+	id, err := db.InsertMap("invoice", inv, nil)
+	assert.NoError(t, err)
+	for _, itemID := range []int{1, 2} {
+		_, err = db.InsertMap("itemNote", map[string]interface{}{"invoiceItemId": itemID, "note": "Note"}, nil)
+		assert.NoError(t, err)
+	}
+	id2, err := db.InsertMap("invoice", map[string]interface{}{"customer": "ACME"}, nil)
+	assert.NoError(t, err)
+
+	// Delete an item on its own, it should stay deleted on restore
+	assert.NoError(t, db.Delete("invoiceItem", 1, nil))
+
+	assert.NoError(t, db.Delete("invoice", id, nil))
+
+	var c int
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM invoiceItem WHERE deletedAt NOTNULL"))
+	assert.Equal(t, 2, c)
+	// All the way down
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(DISTINCT deletedAt) FROM itemNote WHERE deletedAt NOTNULL"))
+	assert.Equal(t, 2, c)
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM itemNote JOIN invoice ON invoice.deletedAt=itemNote.deletedAt"))
+	assert.Equal(t, 1, c)
+
+	_, err = db.GetMap("invoice", id, false)
+	assert.Error(t, err)
+	assert.Error(t, db.Delete("invoice", id, nil))
+	assert.ErrorIs(t, db.UpdateMap("invoice", map[string]interface{}{"id": id, "customer": "X"}, nil), ErrUnknownKey)
+	_, _, err = db.UpsertMap("invoice", map[string]interface{}{"id": id, "customer": "X"}, []string{"id"}, nil)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	m, err := db.GetMapWithOptions("invoice", id, GetOptions{Deleted: WithDeleted, WithRefTables: true})
+	assert.NoError(t, err)
+	assert.NotNil(t, m[DeletedAtField])
+	assert.Equal(t, "Fred Blogs", m["customer"])
+	assert.Len(t, m["invoiceItem_RefTable"], 2)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	getIds := func(query string) []int64 {
+		res, err := http.Get(ts.URL + "/invoice" + query)
+		assert.NoError(t, err)
+		rows := make([]map[string]interface{}, 0)
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&rows))
+		res.Body.Close()
+		ids := []int64{}
+		for _, row := range rows {
+			ids = append(ids, int64(row["id"].(float64)))
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{id2}, getIds(""))
+	assert.Equal(t, []int64{id, id2}, getIds("?withDeleted"))
+	assert.Equal(t, []int64{id}, getIds("?onlyDeleted"))
+
+	// Restore
+	res, err := http.Post(ts.URL+"/invoice/1?restore", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	m, err = db.GetMap("invoice", id, true)
+	assert.NoError(t, err)
+	assert.Nil(t, m[DeletedAtField])
+	items := m["invoiceItem_RefTable"].([]map[string]interface{})
+	assert.Len(t, items, 1)
+	assert.Equal(t, "Item B", items[0]["item"])
+	// The note of item A stays deleted with it
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM itemNote WHERE deletedAt ISNULL"))
+	assert.Equal(t, 1, c)
+	assert.NoError(t, db.DB.Get(&c, "SELECT invoiceItemId FROM itemNote WHERE deletedAt ISNULL"))
+	assert.Equal(t, 2, c)
+
+	assert.ErrorIs(t, db.RestoreRow("invoice", id, nil), ErrNotDeleted)
+
+	// Purge
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/invoice/1?purge", nil)
+	assert.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM invoice"))
+	assert.Equal(t, 1, c)
+	assert.NoError(t, db.DB.Get(&c, "SELECT COUNT(*) FROM invoiceItem"))
+	assert.Equal(t, 0, c)
+}
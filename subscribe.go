@@ -0,0 +1,176 @@
+package sqliteapi
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ChangeInsert   = "insert"
+	ChangeUpdate   = "update"
+	ChangeDelete   = "delete"
+	ChangeFunction = "function"
+)
+
+// SubscribeBufferSize is the number of events buffered for each subscriber, a
+// subscriber that falls further behind is dropped
+var SubscribeBufferSize = 256
+
+// ChangeHistorySize is the number of recent events kept so subscribers can resume
+// from a given event id
+var ChangeHistorySize = 1000
+
+// ChangeEvent is a committed change to a table, or a function call
+type ChangeEvent struct {
+	ID     uint64      `json:"id"`
+	Table  string      `json:"table"`
+	Action string      `json:"action"` // insert, update, delete or function
+	Key    interface{} `json:"key,omitempty"`
+	Data   Map         `json:"data,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+type subscriber struct {
+	tables []string
+	ch     chan ChangeEvent
+}
+
+type subscribers struct {
+	sync.Mutex
+	lastID uint64
+	subs   map[*subscriber]struct{}
+	recent []ChangeEvent
+}
+
+func (s *subscriber) wants(table string) bool {
+	if len(s.tables) == 0 {
+		return true
+	}
+	for _, t := range s.tables {
+		if t == table || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns a channel of committed change events for the given tables (or
+// functions), or all tables if none are given. Events are delivered asynchronously,
+// and a subscriber that does not keep up is dropped by closing the channel, so never
+// blocks writers. Call cancel to unsubscribe.
+func (d *Database) Subscribe(tables ...string) (<-chan ChangeEvent, func()) {
+	_, ch, cancel := d.SubscribeSince(0, tables...)
+	return ch, cancel
+}
+
+// SubscribeSince is Subscribe also returning the recent events (up to ChangeHistorySize)
+// after the event id lastID, so a subscriber can resume without missing events
+func (d *Database) SubscribeSince(lastID uint64, tables ...string) ([]ChangeEvent, <-chan ChangeEvent, func()) {
+	s := &subscriber{
+		tables: tables,
+		ch:     make(chan ChangeEvent, SubscribeBufferSize),
+	}
+
+	d.subscribers.Lock()
+	if d.subscribers.subs == nil {
+		d.subscribers.subs = make(map[*subscriber]struct{})
+	}
+	d.subscribers.subs[s] = struct{}{}
+	missed := make([]ChangeEvent, 0)
+	if lastID > 0 {
+		for _, e := range d.subscribers.recent {
+			if e.ID > lastID && s.wants(e.Table) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	d.subscribers.Unlock()
+
+	cancel := func() {
+		d.subscribers.Lock()
+		defer d.subscribers.Unlock()
+		d.unsubscribe(s)
+	}
+
+	return missed, s.ch, cancel
+}
+
+// unsubscribe removes the subscriber and closes its channel, subscribers must be locked
+func (d *Database) unsubscribe(s *subscriber) {
+	if _, ok := d.subscribers.subs[s]; ok {
+		delete(d.subscribers.subs, s)
+		close(s.ch)
+	}
+}
+
+// publish sends the event to the subscribers without blocking
+func (d *Database) publish(e ChangeEvent) {
+	d.subscribers.Lock()
+	defer d.subscribers.Unlock()
+
+	d.subscribers.lastID++
+	e.ID = d.subscribers.lastID
+	e.Time = time.Now()
+
+	d.subscribers.recent = append(d.subscribers.recent, e)
+	if n := len(d.subscribers.recent) - ChangeHistorySize; n > 0 {
+		d.subscribers.recent = d.subscribers.recent[n:]
+	}
+
+	for s := range d.subscribers.subs {
+		if !s.wants(e.Table) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			d.log.Printf("dropping slow change subscriber to %v", s.tables)
+			d.unsubscribe(s)
+		}
+	}
+}
+
+// publishHook publishes the after hook as a change event, it must only be called once
+// the change has been committed
+func (d *Database) publishHook(params HookParams) {
+	var action string
+	switch params.Action {
+	case HookAfterInsert:
+		action = ChangeInsert
+	case HookAfterUpdate:
+		action = ChangeUpdate
+	case HookAfterDelete:
+		action = ChangeDelete
+	case HookAfterFunction:
+		action = ChangeFunction
+	default:
+		return
+	}
+
+	// Copy the data as it belongs to the caller
+	var data Map
+	if params.Data != nil {
+		data = make(Map, len(params.Data))
+		for k, v := range params.Data {
+			data[k] = v
+		}
+	}
+
+	d.publish(ChangeEvent{
+		Table:  params.Table,
+		Action: action,
+		Key:    params.Key,
+		Data:   data,
+	})
+
+	d.pokeWebhookWorker()
+}
+
+// closeSubscribers closes all the subscriber channels
+func (d *Database) closeSubscribers() {
+	d.subscribers.Lock()
+	defer d.subscribers.Unlock()
+	for s := range d.subscribers.subs {
+		d.unsubscribe(s)
+	}
+}
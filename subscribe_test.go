@@ -0,0 +1,202 @@
+package sqliteapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  table1:
+    id:
+    text:
+  table2:
+    id:
+    text:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ch, cancel := db.Subscribe("table1")
+	defer cancel()
+	all, cancelAll := db.Subscribe()
+
+	next := func(ch <-chan ChangeEvent) ChangeEvent {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+		return ChangeEvent{}
+	}
+
+	id, err := db.InsertMap("table1", map[string]interface{}{"text": "A"}, nil)
+	assert.NoError(t, err)
+	_, err = db.InsertMap("table2", map[string]interface{}{"text": "B"}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.UpdateMap("table1", map[string]interface{}{"id": id, "text": "C"}, nil))
+	assert.NoError(t, db.Delete("table1", id, nil))
+
+	e := next(ch)
+	assert.Equal(t, "table1", e.Table)
+	assert.Equal(t, ChangeInsert, e.Action)
+	assert.Equal(t, id, e.Key)
+	assert.Equal(t, "A", e.Data["text"])
+
+	e2 := next(ch)
+	assert.Equal(t, ChangeUpdate, e2.Action)
+	assert.Greater(t, e2.ID, e.ID)
+	assert.Equal(t, "C", e2.Data["text"])
+
+	assert.Equal(t, ChangeDelete, next(ch).Action)
+
+	assert.Equal(t, "table1", next(all).Table)
+	assert.Equal(t, "table2", next(all).Table)
+
+	// Failed changes are not published
+	assert.Error(t, db.UpdateMap("table1", map[string]interface{}{"id": 999, "text": "D"}, nil))
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+
+	// Nor are deletes rolled back by the after hook
+	id, err = db.InsertMap("table1", map[string]interface{}{"text": "E"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ChangeInsert, next(ch).Action)
+	db.AddHook("table1", func(p HookParams) error {
+		if p.Action == HookAfterDelete {
+			return errors.New("not allowed")
+		}
+		return nil
+	})
+	assert.Error(t, db.Delete("table1", id, nil))
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+	_, err = db.GetMap("table1", id, false)
+	assert.NoError(t, err)
+
+	// Cancel closes the channel
+	cancelAll()
+	cancelAll()
+	for range all {
+	}
+
+	// Slow subscribers are dropped rather than blocking
+	slow, cancelSlow := db.Subscribe("table2")
+	defer cancelSlow()
+	for i := 0; i <= SubscribeBufferSize; i++ {
+		_, err = db.InsertMap("table2", map[string]interface{}{"text": "X"}, nil)
+		assert.NoError(t, err)
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	assert.Equal(t, SubscribeBufferSize, n)
+}
+
+func TestHandleGetEvents(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  table1:
+    id:
+    text:
+    secret:
+      hidden: true
+  table2:
+    id:
+    text:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	defaultHeartbeat := EventsHeartbeat
+	EventsHeartbeat = 20 * time.Millisecond
+	defer func() { EventsHeartbeat = defaultHeartbeat }()
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/_events?tables=table1,gdb_config")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	connect := func(lastID string) (*bufio.Reader, func()) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/_events?tables=table1", nil)
+		assert.NoError(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		return bufio.NewReader(res.Body), func() { res.Body.Close() }
+	}
+
+	// readEvent returns the next event's id and data, and if a heartbeat was received first
+	readEvent := func(r *bufio.Reader) (string, ChangeEvent, bool) {
+		var id string
+		var e ChangeEvent
+		heartbeat := false
+		for {
+			line, err := r.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return id, e, heartbeat
+			}
+			switch {
+			case strings.HasPrefix(line, ": heartbeat"):
+				heartbeat = true
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimSpace(strings.TrimPrefix(line, "id: "))
+			case strings.HasPrefix(line, "data: "):
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+				return id, e, heartbeat
+			}
+		}
+	}
+
+	r, disconnect := connect("")
+
+	_, err = db.InsertMap("table2", map[string]interface{}{"text": "Other"}, nil)
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = db.InsertMap("table1", map[string]interface{}{"text": "A", "secret": "xyz"}, nil)
+	assert.NoError(t, err)
+
+	id, e, heartbeat := readEvent(r)
+	assert.True(t, heartbeat)
+	assert.Equal(t, "table1", e.Table)
+	assert.Equal(t, ChangeInsert, e.Action)
+	assert.Equal(t, "A", e.Data["text"])
+	assert.NotContains(t, e.Data, "secret")
+	disconnect()
+
+	// Resume after the last event received
+	_, err = db.InsertMap("table1", map[string]interface{}{"text": "B"}, nil)
+	assert.NoError(t, err)
+
+	r, disconnect = connect(id)
+	defer disconnect()
+	_, e, _ = readEvent(r)
+	assert.Equal(t, "B", e.Data["text"])
+}
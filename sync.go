@@ -0,0 +1,948 @@
+package sqliteapi
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SyncCreateSql creates the client side sync state: the sequence numbers synced so far,
+// the keys of the rows known to the server, and the ranges of the client change log
+// written by the sync itself, which are not pushed back to the server
+const SyncCreateSql = `
+CREATE TABLE IF NOT EXISTS "gdb_sync" (
+	"name"		TEXT PRIMARY KEY,
+	"value"		INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS "gdb_sync_known" (
+	"tableName"	TEXT NOT NULL,
+	"rowKey"		TEXT NOT NULL,
+	PRIMARY KEY ("tableName", "rowKey")
+);
+CREATE TABLE IF NOT EXISTS "gdb_sync_skip" (
+	"fromSeq"		INTEGER NOT NULL,
+	"toSeq"		INTEGER NOT NULL
+);`
+
+// SyncServerCreateSql creates the server side sync state: the id given by the server to
+// each row created on a client, so a row pushed again (e.g. after the client failed to
+// record the new id) is not inserted twice
+const SyncServerCreateSql = `
+CREATE TABLE IF NOT EXISTS "gdb_sync_ids" (
+	"clientId"	INTEGER NOT NULL,
+	"tableName"	TEXT NOT NULL,
+	"clientKey"	INTEGER NOT NULL,
+	"serverKey"	INTEGER NOT NULL,
+	PRIMARY KEY ("clientId", "tableName", "clientKey")
+);`
+
+const (
+	syncPushedSeq = "pushedSeq" // Client change log sequence pushed
+	syncPulledSeq = "pulledSeq" // Server change log sequence pulled
+	syncClientID  = "clientId"  // Random id of the client, used by the server to map its ids
+)
+
+// syncNotSkipped excludes the client change log entries written by the sync
+const syncNotSkipped = " AND NOT EXISTS (SELECT 1 FROM gdb_sync_skip WHERE seq BETWEEN fromSeq AND toSeq)"
+
+// SyncConflict is a row changed on both the client and the server since the last sync,
+// Client or Server being nil if the row was deleted there
+type SyncConflict struct {
+	Table  string
+	Key    interface{}
+	Client map[string]interface{}
+	Server map[string]interface{}
+}
+
+// ConflictResolver returns the row to keep for a conflict, or nil to delete it
+type ConflictResolver func(c SyncConflict) (map[string]interface{}, error)
+
+// ServerWins keeps the server row, and is the default ConflictResolver
+func ServerWins(c SyncConflict) (map[string]interface{}, error) {
+	return c.Server, nil
+}
+
+// ClientWins keeps the client row
+func ClientWins(c SyncConflict) (map[string]interface{}, error) {
+	return c.Client, nil
+}
+
+// LastWriterWins keeps the row with the latest value of the given field, e.g. updatedAt.
+// Deletes have no value to compare, so the server wins if the row was deleted on either.
+func LastWriterWins(field string) ConflictResolver {
+	return func(c SyncConflict) (map[string]interface{}, error) {
+		if c.Client == nil || c.Server == nil {
+			return c.Server, nil
+		}
+		if syncCompare(c.Client[field], c.Server[field]) > 0 {
+			return c.Client, nil
+		}
+		return c.Server, nil
+	}
+}
+
+// syncCompare compares times, numbers or otherwise their string values
+func syncCompare(a, b interface{}) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.After(tb):
+				return 1
+			case ta.Before(tb):
+				return -1
+			}
+			return 0
+		}
+	}
+	if fa, ok := syncNumber(a); ok {
+		if fb, ok := syncNumber(b); ok {
+			switch {
+			case fa > fb:
+				return 1
+			case fa < fb:
+				return -1
+			}
+			return 0
+		}
+	}
+	if a == nil || b == nil {
+		switch {
+		case a != nil:
+			return 1
+		case b != nil:
+			return -1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+func syncNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+type SyncOptions struct {
+	Tables    []string                    // Tables to sync, defaults to all
+	Resolvers map[string]ConflictResolver // Per table, ServerWins by default
+	User      User                        // Used for the changes made to the server
+}
+
+type SyncResult struct {
+	Pushed    int `json:"pushed"`    // Rows changed on the server
+	Pulled    int `json:"pulled"`    // Rows changed on the client
+	Conflicts int `json:"conflicts"` // Rows changed on both
+	Remapped  int `json:"remapped"`  // Rows created on the client given a new id by the server
+}
+
+type syncer struct {
+	client    *Database
+	server    *Database
+	opts      *SyncOptions
+	clientID  int64
+	tables    []string // In dependency order
+	result    *SyncResult
+	pushedSeq int64
+	pulledSeq int64
+	pushMax   int64
+	moved     map[string]map[string]interface{} // Current key of client rows moved by remapping
+}
+
+// Sync pushes the changes made to this (client) database since the last sync to the
+// server database, and then pulls the server's changes. Both must have the ChangeLog
+// option. Rows changed on both since the last sync are resolved by the table's
+// ConflictResolver. Rows created on the client with an integer primary key are given
+// a new id by the server, which replaces the client id (and the references to it), so
+// rows can be created offline without clashing.
+func (d *Database) Sync(server *Database, opts *SyncOptions) (*SyncResult, error) {
+	if !d.changeLog || !server.changeLog {
+		return nil, ErrNoChangeLog
+	}
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+
+	d.syncing.Lock()
+	defer d.syncing.Unlock()
+
+	_, err := d.DB.Exec(SyncCreateSql)
+	if err != nil {
+		return nil, fmt.Errorf("error creating sync tables: %w", err)
+	}
+	_, err = server.DB.Exec(SyncServerCreateSql)
+	if err != nil {
+		return nil, fmt.Errorf("error creating server sync tables: %w", err)
+	}
+
+	s := &syncer{
+		client: d,
+		server: server,
+		opts:   opts,
+		result: &SyncResult{},
+		moved:  make(map[string]map[string]interface{}),
+	}
+
+	s.tables, err = d.syncTables(server, opts.Tables)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pushedSeq, err = syncState(d.DB, syncPushedSeq)
+	if err != nil {
+		return nil, err
+	}
+	s.pulledSeq, err = syncState(d.DB, syncPulledSeq)
+	if err != nil {
+		return nil, err
+	}
+	s.clientID, err = syncClientState(d.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.push()
+	if err != nil {
+		return s.result, fmt.Errorf("push: %w", err)
+	}
+	err = s.pull()
+	if err != nil {
+		return s.result, fmt.Errorf("pull: %w", err)
+	}
+
+	d.log.Printf("Sync: pushed %d, pulled %d, conflicts %d, remapped %d",
+		s.result.Pushed, s.result.Pulled, s.result.Conflicts, s.result.Remapped)
+
+	return s.result, nil
+}
+
+// syncTables returns the tables to sync in dependency order, referenced tables first
+func (d *Database) syncTables(server *Database, tables []string) ([]string, error) {
+	canSync := func(table string) bool {
+		for _, db := range []*Database{d, server} {
+			ti := db.dbInfo.GetTableInfo(table)
+			if ti == nil || ti.IsView || ti.GetPrimaryKey().Field == "?" {
+				return false
+			}
+		}
+		return true
+	}
+
+	names := []string{}
+	if len(tables) > 0 {
+		for _, t := range tables {
+			if !canSync(t) {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownTable, t)
+			}
+			names = append(names, t)
+		}
+	} else if d.getConfig() != nil {
+		for _, t := range d.getConfig().Tables {
+			if canSync(t.Name) {
+				names = append(names, t.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return d.getConfig().DependencyOrder(names), nil
+}
+
+func (s *syncer) tableIndex(table string) int {
+	for i, t := range s.tables {
+		if t == table {
+			return i
+		}
+	}
+	return len(s.tables)
+}
+
+// push sends the client rows changed since the last push to the server, referenced
+// rows first so they have their server ids before the rows referencing them
+func (s *syncer) push() error {
+	d := s.client
+
+	err := d.DB.Get(&s.pushMax, "SELECT IFNULL(MAX(seq), 0) FROM "+ChangesTable)
+	if err != nil {
+		return err
+	}
+	if len(s.tables) == 0 {
+		return nil
+	}
+
+	q := "SELECT MAX(seq), tableName, rowKey FROM " + ChangesTable + " WHERE seq>? AND seq<=?" + syncNotSkipped
+	q += " AND tableName IN (?" + strings.Repeat(",?", len(s.tables)-1) + ")"
+	q += " GROUP BY tableName, rowKey ORDER BY 1"
+	args := []interface{}{s.pushedSeq, s.pushMax}
+	for _, t := range s.tables {
+		args = append(args, t)
+	}
+	entries, _, err := scanChangeLog(d.DB, 0, q, args...)
+	if err != nil {
+		return err
+	}
+
+	// Upserts in dependency order, then deletes in reverse
+	upserts := []changeLogEntry{}
+	deletes := []changeLogEntry{}
+	for _, e := range entries {
+		row, err := syncRow(d, d.DB, e.table, e.keyValues)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			upserts = append(upserts, e)
+		} else {
+			deletes = append(deletes, e)
+		}
+	}
+	sort.SliceStable(upserts, func(a, b int) bool {
+		return s.tableIndex(upserts[a].table) < s.tableIndex(upserts[b].table)
+	})
+	sort.SliceStable(deletes, func(a, b int) bool {
+		return s.tableIndex(deletes[a].table) > s.tableIndex(deletes[b].table)
+	})
+
+	for _, e := range append(upserts, deletes...) {
+		err = s.pushRow(e.table, s.currentKey(e.table, e.keyValues))
+		if err != nil {
+			return fmt.Errorf("%s %v: %w", e.table, keyFromValues(e.keyValues), err)
+		}
+	}
+
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer tx.Rollback()
+	err = setSyncStateWithTx(tx, syncPushedSeq, s.pushMax)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM gdb_sync_skip WHERE toSeq<=?", s.pushMax)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	done()
+	return err
+}
+
+// currentKey returns the key of the client row, which may have been moved by remapping
+func (s *syncer) currentKey(table string, keyValues []interface{}) []interface{} {
+	if moved, ok := s.moved[table]; ok {
+		if v, ok := moved[fmt.Sprintf("%v", keyValues)]; ok {
+			return []interface{}{v}
+		}
+	}
+	return keyValues
+}
+
+func (s *syncer) pushRow(table string, keyValues []interface{}) error {
+	d := s.client
+
+	row, err := syncRow(d, d.DB, table, keyValues)
+	if err != nil {
+		return err
+	}
+	known, err := syncIsKnown(d.DB, table, keyValues)
+	if err != nil {
+		return err
+	}
+
+	if !known {
+		if row == nil {
+			return nil // Created and deleted since the last sync
+		}
+		if pk := syncIntegerKey(d, table); pk != "" {
+			return s.pushNewRow(table, pk, keyValues[0], row)
+		}
+		// Other keys, e.g. uuids, are assumed to be unique
+	}
+
+	serverRow, err := syncRow(s.server, s.server.DB, table, keyValues)
+	if err != nil {
+		return err
+	}
+
+	keep := row
+	if !reflect.DeepEqual(row, serverRow) {
+		seq, err := syncLastChange(s.server.DB, table, keyValues, "")
+		if err != nil {
+			return err
+		}
+		if seq > s.pulledSeq {
+			// Changed on both
+			s.result.Conflicts++
+			resolver := s.opts.Resolvers[table]
+			if resolver == nil {
+				resolver = ServerWins
+			}
+			keep, err = resolver(SyncConflict{
+				Table:  table,
+				Key:    keyFromValues(keyValues),
+				Client: row,
+				Server: serverRow,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if !reflect.DeepEqual(keep, serverRow) {
+			err = s.server.syncWrite(table, keyValues, keep, s.opts.User)
+			if err != nil {
+				return err
+			}
+			s.result.Pushed++
+		}
+	}
+
+	if keep == nil {
+		return nil
+	}
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer tx.Rollback()
+	err = syncMarkKnownWithTx(tx, table, keyValues)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	done()
+	return err
+}
+
+// pushNewRow inserts a row created on the client, replacing the client id with the
+// one given by the server. A row already inserted by an earlier sync which failed
+// before the client recorded the new id is not inserted again, but pushed as a row
+// known to the server.
+func (s *syncer) pushNewRow(table string, pk string, clientID interface{}, row map[string]interface{}) error {
+	d := s.client
+
+	data := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		if k != pk {
+			data[k] = v
+		}
+	}
+	serverID, inserted, err := s.server.syncInsert(table, data, s.opts.User, s.clientID, clientID)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		s.result.Pushed++
+	}
+
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer tx.Rollback()
+
+	err = syncSkipWithTx(tx, func() error {
+		if fmt.Sprintf("%v", clientID) == fmt.Sprintf("%v", serverID) {
+			return nil
+		}
+		s.result.Remapped++
+
+		_, err := tx.Exec("PRAGMA defer_foreign_keys=ON")
+		if err != nil {
+			return err
+		}
+
+		// Move any other client row already using the server id out of the way
+		existing, err := syncRow(d, tx, table, []interface{}{serverID})
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			var maxID int64
+			err = tx.Get(&maxID, "SELECT MAX(`"+pk+"`) FROM `"+table+"`")
+			if err != nil {
+				return err
+			}
+			err = d.syncMoveKeyWithTx(tx, table, pk, serverID, maxID+1)
+			if err != nil {
+				return err
+			}
+			s.setMoved(table, serverID, maxID+1)
+		}
+
+		return d.syncMoveKeyWithTx(tx, table, pk, clientID, serverID)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = syncMarkKnownWithTx(tx, table, []interface{}{serverID})
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	done()
+	if err != nil || inserted {
+		return err
+	}
+	return s.pushRow(table, []interface{}{serverID})
+}
+
+// setMoved records that the client row with key from is now to
+func (s *syncer) setMoved(table string, from, to interface{}) {
+	moved, ok := s.moved[table]
+	if !ok {
+		moved = make(map[string]interface{})
+		s.moved[table] = moved
+	}
+	found := false
+	for k, v := range moved {
+		if fmt.Sprintf("%v", v) == fmt.Sprintf("%v", from) {
+			moved[k] = to
+			found = true
+		}
+	}
+	if !found {
+		moved[fmt.Sprintf("%v", []interface{}{from})] = to
+	}
+}
+
+// pull applies the server rows changed since the last pull to the client, in a single
+// transaction. Rows changed on the client since the push are left to be resolved by
+// the next sync.
+func (s *syncer) pull() error {
+	type pulled struct {
+		changeLogEntry
+		row map[string]interface{}
+	}
+	rows := []pulled{}
+
+	since := s.pulledSeq
+	lastSeq := s.pulledSeq
+	if len(s.tables) > 0 {
+		stx, err := s.server.DB.Beginx()
+		if err != nil {
+			return err
+		}
+		defer stx.Rollback() // This is a query so we always rollback
+
+		err = stx.Get(&lastSeq, "SELECT IFNULL(MAX(seq), ?) FROM "+ChangesTable, since)
+		if err != nil {
+			return err
+		}
+		for {
+			entries, more, err := changeLogWithTx(stx, since, ChangesLimit, s.tables)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				row, err := syncRow(s.server, stx, e.table, e.keyValues)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, pulled{e, row})
+			}
+			if !more {
+				break
+			}
+			since = entries[len(entries)-1].seq
+		}
+		stx.Rollback()
+	}
+
+	d := s.client
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer tx.Rollback()
+
+	after := []HookParams{}
+	err = syncSkipWithTx(tx, func() error {
+		_, err := tx.Exec("PRAGMA defer_foreign_keys=ON")
+		if err != nil {
+			return err
+		}
+		for _, p := range rows {
+			seq, err := syncLastChange(tx, p.table, p.keyValues, syncNotSkipped)
+			if err != nil {
+				return err
+			}
+			if seq > s.pushMax {
+				continue // Changed on the client during the sync
+			}
+			current, err := syncRow(d, tx, p.table, p.keyValues)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(current, p.row) {
+				continue
+			}
+			hp, err := d.syncWriteWithTx(tx, p.table, p.keyValues, p.row, nil)
+			if err != nil {
+				return fmt.Errorf("%s %v: %w", p.table, keyFromValues(p.keyValues), err)
+			}
+			after = append(after, hp)
+			if p.row != nil {
+				err = syncMarkKnownWithTx(tx, p.table, p.keyValues)
+				if err != nil {
+					return err
+				}
+			}
+			s.result.Pulled++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = setSyncStateWithTx(tx, syncPulledSeq, lastSeq)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return err
+	}
+
+	for _, hp := range after {
+		err = d.runAfterHooks(hp.Table, hp)
+		if err != nil {
+			d.log.Printf("error running after sync pull hook: %s", err)
+		}
+	}
+	return nil
+}
+
+// syncWrite writes (or deletes if row is nil) the row, running the hooks
+func (d *Database) syncWrite(table string, keyValues []interface{}, row map[string]interface{}, user User) error {
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer tx.Rollback()
+
+	hp, err := d.syncWriteWithTx(tx, table, keyValues, row, user)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return err
+	}
+	return d.runAfterHooks(table, hp)
+}
+
+// syncWriteWithTx writes all the fields of the row as is (including hidden and readonly
+// fields) inserting or updating it, or deletes it if row is nil, after running the
+// before hook. Returns the params for the after hook to run once committed.
+func (d *Database) syncWriteWithTx(tx *sqlx.Tx, table string, keyValues []interface{}, row map[string]interface{}, user User) (HookParams, error) {
+	hp := HookParams{
+		Table: table,
+		Key:   keyFromValues(keyValues),
+		Data:  row,
+		Tx:    tx,
+		User:  user,
+	}
+
+	ti := d.dbInfo.GetTableInfo(table)
+	if ti == nil {
+		return hp, ErrUnknownTable
+	}
+	where := ti.KeyWhere()
+
+	if row == nil {
+		hp.Action = HookBeforeDelete
+		err := d.runHooks(table, hp)
+		if err != nil {
+			return hp, err
+		}
+		hp.Action = HookAfterDelete
+		return hp, d.auditWithTx(tx, table, AuditDelete, where, keyValues, user, func() error {
+			_, err := tx.Exec("DELETE FROM `"+table+"` WHERE "+where, keyValues...)
+			return err
+		})
+	}
+
+	existing, err := syncRow(d, tx, table, keyValues)
+	if err != nil {
+		return hp, err
+	}
+
+	hp.Action = HookBeforeUpdate
+	if existing == nil {
+		hp.Action = HookBeforeInsert
+	}
+	err = d.runHooks(table, hp)
+	if err != nil {
+		return hp, err
+	}
+
+	fields, values, err := d.syncFields(ti, row)
+	if err != nil {
+		return hp, err
+	}
+
+	if existing == nil {
+		hp.Action = HookAfterInsert
+		res, err := tx.Exec("INSERT INTO `"+table+"` (`"+strings.Join(fields, "`,`")+"`) VALUES (?"+
+			strings.Repeat(",?", len(fields)-1)+")", values...)
+		if err != nil {
+			return hp, err
+		}
+		rowid, _ := res.LastInsertId()
+		return hp, d.auditInsertWithTx(tx, table, rowid, user)
+	}
+
+	hp.Action = HookAfterUpdate
+	return hp, d.auditWithTx(tx, table, AuditUpdate, where, keyValues, user, func() error {
+		_, err := tx.Exec("UPDATE `"+table+"` SET `"+strings.Join(fields, "`=?,`")+"`=? WHERE "+where,
+			append(values, keyValues...)...)
+		return err
+	})
+}
+
+// syncFields returns the fields of the table in the row and their values, checked by
+// FieldValidation
+func (d *Database) syncFields(ti *TableInfo, row map[string]interface{}) ([]string, []interface{}, error) {
+	fields := []string{}
+	values := []interface{}{}
+	for _, f := range ti.Fields {
+		if v, ok := row[f.Name]; ok {
+			if b, ok := v.([]byte); ok && !strings.EqualFold(f.Type, "BLOB") {
+				v = string(b)
+			}
+			err := d.FieldValidation(ti.Name, f.Name, v)
+			if err != nil {
+				return nil, nil, err
+			}
+			fields = append(fields, f.Name)
+			values = append(values, v)
+		}
+	}
+	return fields, values, nil
+}
+
+// syncInsert inserts the row created on the client with the fields as is, returning
+// the new id. The id is recorded against the client's id for the row, in the same
+// transaction, and returned with inserted false if the row was inserted before.
+func (d *Database) syncInsert(table string, data map[string]interface{}, user User, clientID int64, clientKey interface{}) (id int64, inserted bool, err error) {
+	ti := d.dbInfo.GetTableInfo(table)
+	if ti == nil {
+		return 0, false, ErrUnknownTable
+	}
+
+	tx, done, err := d.beginWrite(context.Background())
+	if err != nil {
+		return 0, false, err
+	}
+	defer done()
+	defer tx.Rollback()
+
+	err = tx.Get(&id, "SELECT serverKey FROM gdb_sync_ids WHERE clientId=? AND tableName=? AND clientKey=?",
+		clientID, table, clientKey)
+	if err == nil {
+		return id, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	err = d.runHooks(table, HookParams{table, nil, data, HookBeforeInsert, tx, user})
+	if err != nil {
+		return 0, false, err
+	}
+
+	fields, values, err := d.syncFields(ti, data)
+	if err != nil {
+		return 0, false, err
+	}
+	q := "INSERT INTO `" + table + "` DEFAULT VALUES"
+	if len(fields) > 0 {
+		q = "INSERT INTO `" + table + "` (`" + strings.Join(fields, "`,`") + "`) VALUES (?" +
+			strings.Repeat(",?", len(fields)-1) + ")"
+	}
+	res, err := tx.Exec(q, values...)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	err = d.auditInsertWithTx(tx, table, id, user)
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = tx.Exec("INSERT INTO gdb_sync_ids (clientId, tableName, clientKey, serverKey) VALUES (?, ?, ?, ?)",
+		clientID, table, clientKey, id)
+	if err != nil {
+		return 0, false, err
+	}
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, true, d.runAfterHooks(table, HookParams{table, id, data, HookAfterInsert, tx, user})
+}
+
+// syncMoveKeyWithTx changes the integer primary key of the row, and the references to it
+func (d *Database) syncMoveKeyWithTx(tx *sqlx.Tx, table string, pk string, from, to interface{}) error {
+	_, err := tx.Exec("UPDATE `"+table+"` SET `"+pk+"`=? WHERE `"+pk+"`=?", to, from)
+	if err != nil {
+		return err
+	}
+	for _, ct := range d.getConfig().Tables {
+		for _, f := range ct.Fields {
+			if f.References == "" {
+				continue
+			}
+			ref, err := NewReference(f.References)
+			if err != nil || ref.Table != table || ref.KeyField != pk {
+				continue
+			}
+			_, err = tx.Exec("UPDATE `"+ct.Name+"` SET `"+f.Name+"`=? WHERE `"+f.Name+"`=?", to, from)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncIntegerKey returns the primary key field if it is a single integer (the rowid),
+// or "" if not
+func syncIntegerKey(d *Database, table string) string {
+	ti := d.dbInfo.GetTableInfo(table)
+	if ti == nil {
+		return ""
+	}
+	pks := ti.GetPrimaryKeyFields()
+	if len(pks) != 1 {
+		return ""
+	}
+	for _, f := range ti.Fields {
+		if f.Name == pks[0] && strings.EqualFold(f.Type, "INTEGER") {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// syncRow returns the row with all its fields, or nil if it does not exist
+func syncRow(d *Database, q sqlx.Queryer, table string, keyValues []interface{}) (map[string]interface{}, error) {
+	ti := d.dbInfo.GetTableInfo(table)
+	if ti == nil {
+		return nil, ErrUnknownTable
+	}
+	row := make(map[string]interface{})
+	err := q.QueryRowx("SELECT * FROM `"+table+"` WHERE "+ti.KeyWhere(), keyValues...).MapScan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return row, err
+}
+
+func syncKeySql(keyValues []interface{}) string {
+	return "json_array(?" + strings.Repeat(",?", len(keyValues)-1) + ")"
+}
+
+// syncLastChange returns the sequence number of the last change to the row
+func syncLastChange(q sqlx.Queryer, table string, keyValues []interface{}, filter string) (int64, error) {
+	var seq int64
+	err := sqlx.Get(q, &seq, "SELECT IFNULL(MAX(seq), 0) FROM "+ChangesTable+
+		" WHERE tableName=? AND rowKey="+syncKeySql(keyValues)+filter,
+		append([]interface{}{table}, keyValues...)...)
+	return seq, err
+}
+
+func syncIsKnown(q sqlx.Queryer, table string, keyValues []interface{}) (bool, error) {
+	var n int
+	err := sqlx.Get(q, &n, "SELECT COUNT(*) FROM gdb_sync_known WHERE tableName=? AND rowKey="+syncKeySql(keyValues),
+		append([]interface{}{table}, keyValues...)...)
+	return n > 0, err
+}
+
+func syncMarkKnownWithTx(tx *sqlx.Tx, table string, keyValues []interface{}) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO gdb_sync_known (tableName, rowKey) VALUES (?, "+syncKeySql(keyValues)+")",
+		append([]interface{}{table}, keyValues...)...)
+	return err
+}
+
+// syncSkipWithTx runs fn, recording the client change log entries it writes so they
+// are not pushed back to the server
+func syncSkipWithTx(tx *sqlx.Tx, fn func() error) error {
+	var before, after int64
+	err := tx.Get(&before, "SELECT IFNULL(MAX(seq), 0) FROM "+ChangesTable)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if err != nil {
+		return err
+	}
+	err = tx.Get(&after, "SELECT IFNULL(MAX(seq), 0) FROM "+ChangesTable)
+	if err != nil {
+		return err
+	}
+	if after > before {
+		_, err = tx.Exec("INSERT INTO gdb_sync_skip (fromSeq, toSeq) VALUES (?, ?)", before+1, after)
+	}
+	return err
+}
+
+func syncState(q sqlx.Queryer, name string) (int64, error) {
+	var v int64
+	err := sqlx.Get(q, &v, "SELECT value FROM gdb_sync WHERE name=?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// syncClientState returns the random id of the client, creating it on the first sync
+func syncClientState(db *sqlx.DB) (int64, error) {
+	id, err := syncState(db, syncClientID)
+	if err != nil || id != 0 {
+		return id, err
+	}
+	b := make([]byte, 8)
+	_, err = rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	id = int64(binary.BigEndian.Uint64(b) >> 1)
+	_, err = db.Exec("INSERT INTO gdb_sync (name, value) VALUES (?, ?)", syncClientID, id)
+	return id, err
+}
+
+func setSyncStateWithTx(tx *sqlx.Tx, name string, value int64) error {
+	_, err := tx.Exec("INSERT INTO gdb_sync (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value=excluded.value",
+		name, value)
+	return err
+}
@@ -0,0 +1,201 @@
+package sqliteapi
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSync(t *testing.T) {
+	const yaml = `
+tables:
+  customer:
+    id:
+    name:
+    updatedAt:
+  job:
+    id:
+    customerId:
+      type: integer
+      ref: customer.id/name
+    notes:
+`
+	dir := t.TempDir()
+	server, err := NewDatabase(filepath.Join(dir, "server.db"), YamlConfig([]byte(yaml)), ChangeLog())
+	assert.NoError(t, err)
+	defer server.Close()
+	client, err := NewDatabase(filepath.Join(dir, "client.db"), YamlConfig([]byte(yaml)), ChangeLog())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	opts := &SyncOptions{
+		Resolvers: map[string]ConflictResolver{
+			"customer": LastWriterWins("updatedAt"),
+			"job": func(c SyncConflict) (map[string]interface{}, error) {
+				merged := make(map[string]interface{})
+				for k, v := range c.Server {
+					merged[k] = v
+				}
+				merged["notes"] = c.Server["notes"].(string) + "; " + c.Client["notes"].(string)
+				return merged, nil
+			},
+		},
+	}
+
+	get := func(db *Database, table string, key interface{}) map[string]interface{} {
+		m, err := db.GetMap(table, key, false)
+		if err != nil {
+			return nil
+		}
+		return m
+	}
+
+	a, err := server.InsertMap("customer", map[string]interface{}{"name": "A", "updatedAt": "2026-01-01"}, nil)
+	assert.NoError(t, err)
+	b, err := server.InsertMap("customer", map[string]interface{}{"name": "B", "updatedAt": "2026-01-01"}, nil)
+	assert.NoError(t, err)
+
+	res, err := client.Sync(server, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncResult{Pulled: 2}, res)
+	assert.Equal(t, "A", get(client, "customer", a)["name"])
+
+	// Offline changes on both
+	c, err := client.InsertMap("customer", map[string]interface{}{"name": "C"}, nil)
+	assert.NoError(t, err)
+	job, err := client.InsertMap("job", map[string]interface{}{"customerId": c, "notes": "Fix"}, nil)
+	assert.NoError(t, err)
+	d, err := server.InsertMap("customer", map[string]interface{}{"name": "D"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, c, d) // Same id, different rows
+
+	assert.NoError(t, client.UpdateMap("customer", map[string]interface{}{"id": a, "name": "A client", "updatedAt": "2026-01-03"}, nil))
+	assert.NoError(t, server.UpdateMap("customer", map[string]interface{}{"id": a, "name": "A server", "updatedAt": "2026-01-02"}, nil))
+	assert.NoError(t, client.UpdateMap("customer", map[string]interface{}{"id": b, "name": "B client", "updatedAt": "2026-01-02"}, nil))
+	assert.NoError(t, server.UpdateMap("customer", map[string]interface{}{"id": b, "name": "B server", "updatedAt": "2026-01-03"}, nil))
+
+	res, err = client.Sync(server, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Conflicts)
+	assert.Equal(t, 1, res.Remapped)
+
+	for _, db := range []*Database{client, server} {
+		assert.Equal(t, "A client", get(db, "customer", a)["name"])
+		assert.Equal(t, "B server", get(db, "customer", b)["name"])
+		assert.Equal(t, "D", get(db, "customer", d)["name"])
+		var n int
+		assert.NoError(t, db.DB.Get(&n, "SELECT COUNT(*) FROM customer"))
+		assert.Equal(t, 4, n)
+	}
+
+	// The client rows have their server ids, including the references
+	newC := get(server, "customer", int64(4))
+	assert.Equal(t, "C", newC["name"])
+	assert.Equal(t, "C", get(client, "customer", int64(4))["name"])
+	assert.Equal(t, int64(4), get(client, "job", job)["customerId"])
+	assert.Equal(t, int64(4), get(server, "job", job)["customerId"])
+
+	// Nothing to do
+	res, err = client.Sync(server, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncResult{}, res)
+
+	// Custom resolver
+	assert.NoError(t, client.UpdateMap("job", map[string]interface{}{"id": job, "notes": "Client notes"}, nil))
+	assert.NoError(t, server.UpdateMap("job", map[string]interface{}{"id": job, "notes": "Server notes"}, nil))
+	res, err = client.Sync(server, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Conflicts)
+	assert.Equal(t, "Server notes; Client notes", get(client, "job", job)["notes"])
+	assert.Equal(t, "Server notes; Client notes", get(server, "job", job)["notes"])
+
+	// Deletes
+	assert.NoError(t, server.Delete("customer", d, nil))
+	assert.NoError(t, client.Delete("job", job, nil))
+	res, err = client.Sync(server, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncResult{Pushed: 1, Pulled: 1}, res)
+	assert.Nil(t, get(client, "customer", d))
+	assert.Nil(t, get(server, "job", job))
+
+	// A client row already using the server's new id is moved out of the way
+	e, err := client.InsertMap("customer", map[string]interface{}{"name": "E"}, nil)
+	assert.NoError(t, err)
+	_, err = client.InsertMap("customer", map[string]interface{}{"name": "F"}, nil)
+	assert.NoError(t, err)
+	g, err := server.InsertMap("customer", map[string]interface{}{"name": "G"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, e, g)
+	res, err = client.Sync(server, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Pushed)
+	assert.Equal(t, 1, res.Remapped)
+	for _, db := range []*Database{client, server} {
+		assert.Equal(t, "G", get(db, "customer", g)["name"])
+		assert.Equal(t, "E", get(db, "customer", g+1)["name"])
+		assert.Equal(t, "F", get(db, "customer", g+2)["name"])
+	}
+}
+
+func TestSyncPushIdempotent(t *testing.T) {
+	const yaml = `
+tables:
+  customer:
+    id:
+    name:
+      regex: "^[A-Z]"
+`
+	dir := t.TempDir()
+	server, err := NewDatabase(filepath.Join(dir, "server.db"), YamlConfig([]byte(yaml)), ChangeLog())
+	assert.NoError(t, err)
+	defer server.Close()
+	client, err := NewDatabase(filepath.Join(dir, "client.db"), YamlConfig([]byte(yaml)), ChangeLog())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	actions := []HookAction{}
+	server.AddHook("customer", func(p HookParams) error {
+		actions = append(actions, p.Action)
+		return nil
+	})
+
+	a, err := client.InsertMap("customer", map[string]interface{}{"name": "A"}, nil)
+	assert.NoError(t, err)
+
+	// The server inserted the row but the client failed to record its new id
+	_, err = client.DB.Exec(SyncCreateSql)
+	assert.NoError(t, err)
+	_, err = server.DB.Exec(SyncServerCreateSql)
+	assert.NoError(t, err)
+	clientID, err := syncClientState(client.DB)
+	assert.NoError(t, err)
+	_, err = server.InsertMap("customer", map[string]interface{}{"name": "B"}, nil)
+	assert.NoError(t, err)
+	id, inserted, err := server.syncInsert("customer", map[string]interface{}{"name": "A"}, nil, clientID, a)
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	assert.Equal(t, []HookAction{HookBeforeInsert, HookAfterInsert, HookBeforeInsert, HookAfterInsert}, actions)
+
+	res, err := client.Sync(server, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Pushed)
+	assert.Equal(t, 1, res.Remapped)
+	for _, db := range []*Database{client, server} {
+		var n int
+		assert.NoError(t, db.DB.Get(&n, "SELECT COUNT(*) FROM customer WHERE name='A'"))
+		assert.Equal(t, 1, n)
+		m, err := db.GetMap("customer", id, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "A", m["name"])
+	}
+
+	// Rows are validated by the server
+	_, err = client.DB.Exec("INSERT INTO customer (name) VALUES ('lower')")
+	assert.NoError(t, err)
+	_, err = client.Sync(server, nil)
+	assert.ErrorContains(t, err, "invalid format")
+	var n int
+	assert.NoError(t, server.DB.Get(&n, "SELECT COUNT(*) FROM customer WHERE name='lower'"))
+	assert.Equal(t, 0, n)
+}
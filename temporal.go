@@ -0,0 +1,204 @@
+package sqliteapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	HistoryTablePrefix = "gdb_history_"
+	ValidFromField     = "validFrom"
+	ValidToField       = "validTo"
+)
+
+var ErrNotTemporal = errors.New("not a temporal table")
+
+// historyTimeFormat is the format of validFrom/validTo, which are compared as strings
+const historyTimeFormat = "2006-01-02 15:04:05.000"
+
+const historyNow = "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+
+// asOfFormats are the accepted formats of asOf, times without a zone are UTC
+var asOfFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseAsOf parses an asOf time e.g. 2026-09-30T23:59:59
+func ParseAsOf(s string) (time.Time, error) {
+	for _, f := range asOfFormats {
+		if t, err := time.Parse(f, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid asOf time '%s'", s)
+}
+
+// HistoryTable returns the name of the table holding the history of a temporal table
+func HistoryTable(table string) string {
+	return HistoryTablePrefix + table
+}
+
+func (d *Database) isTemporal(table string) bool {
+	ct := d.getConfig().GetTable(table)
+	return ct != nil && ct.Temporal
+}
+
+// AddAsOf selects from the history of the temporal tables rather than the tables
+// themselves, returning the rows as they were at the given time
+func (d *Database) AddAsOf(sb *SelectBuilder, asOf time.Time) error {
+	if !d.isTemporal(sb.From) {
+		return fmt.Errorf("%s: %w", sb.From, ErrNotTemporal)
+	}
+	ts := asOf.UTC().Format(historyTimeFormat)
+	if sb.Sources == nil {
+		sb.Sources = make(map[string]string)
+	}
+	for _, t := range d.getConfig().Tables {
+		if t.Temporal {
+			sb.Sources[t.Name] = "SELECT * FROM `" + HistoryTable(t.Name) + "`" +
+				" WHERE `" + ValidFromField + "`<='" + ts + "'" +
+				" AND (`" + ValidToField + "` ISNULL OR `" + ValidToField + "`>'" + ts + "')"
+		}
+	}
+	return nil
+}
+
+// historyCreateSQL returns the SQL to create the history table, which has the same
+// columns as the table (without constraints) plus validFrom and validTo
+func (table *ConfigTable) historyCreateSQL() string {
+	name := HistoryTable(table.Name)
+	coldefs := []string{}
+	for _, f := range table.Fields {
+		coldefs = append(coldefs, historyColDef(f))
+	}
+	coldefs = append(coldefs,
+		"`"+ValidFromField+"` TEXT NOT NULL",
+		"`"+ValidToField+"` TEXT",
+	)
+
+	sql := "CREATE TABLE \"" + name + "\" (\n\t"
+	sql += strings.Join(coldefs, ",\n\t")
+	sql += ");\n"
+	sql += "CREATE INDEX \"" + name + "_key\" ON \"" + name + "\" (`"
+	sql += strings.Join(append(table.PrimaryKeys(), ValidFromField), "`,`") + "`)"
+	return sql
+}
+
+func historyColDef(f ConfigField) string {
+	datatype := strings.ToUpper(f.Type)
+	if datatype == "" {
+		datatype = "TEXT"
+	}
+	return fmt.Sprintf("`%s` %s", f.Name, datatype)
+}
+
+// historyTriggers returns the triggers that keep the history table up to date
+func (table *ConfigTable) historyTriggers() []ConfigTrigger {
+	name := HistoryTable(table.Name)
+
+	fields := []string{}
+	newFields := []string{}
+	for _, f := range table.Fields {
+		fields = append(fields, "`"+f.Name+"`")
+		newFields = append(newFields, "new.`"+f.Name+"`")
+	}
+	keyMatch := []string{}
+	for _, pk := range table.PrimaryKeys() {
+		keyMatch = append(keyMatch, "`"+pk+"`=old.`"+pk+"`")
+	}
+
+	insert := "INSERT INTO `" + name + "` (" + strings.Join(fields, ",") + ",`" + ValidFromField + "`)"
+	insert += " VALUES (" + strings.Join(newFields, ",") + "," + historyNow + ");"
+	end := "UPDATE `" + name + "` SET `" + ValidToField + "`=" + historyNow
+	end += " WHERE " + strings.Join(keyMatch, " AND ") + " AND `" + ValidToField + "` ISNULL;"
+
+	return []ConfigTrigger{
+		{
+			Name:      name + "_insert",
+			Event:     "AFTER INSERT",
+			Table:     table.Name,
+			Statement: insert,
+		},
+		{
+			Name:      name + "_update",
+			Event:     "AFTER UPDATE",
+			Table:     table.Name,
+			Statement: end + "\n\t" + insert,
+		},
+		{
+			Name:      name + "_delete",
+			Event:     "AFTER DELETE",
+			Table:     table.Name,
+			Statement: end,
+		},
+	}
+}
+
+// historyTriggers returns the triggers for all the temporal tables
+func (c *Config) historyTriggers() []ConfigTrigger {
+	ret := make([]ConfigTrigger, 0)
+	for _, t := range c.Tables {
+		if t.Temporal {
+			ret = append(ret, t.historyTriggers()...)
+		}
+	}
+	return ret
+}
+
+// applyHistoryTableWithTx creates the history table if needed, starting the history
+// with the existing rows, or adds any new columns. Returns a description of the change
+// or "" if there was none.
+func applyHistoryTableWithTx(tx *sqlx.Tx, table *ConfigTable, slog func(string) string) (string, error) {
+	name := HistoryTable(table.Name)
+
+	columns := []string{}
+	err := tx.Select(&columns, "SELECT name FROM pragma_table_info(?)", name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(columns) == 0 {
+		_, err = tx.Exec(slog(table.historyCreateSQL()))
+		if err != nil {
+			return "", err
+		}
+		fields := "`" + strings.Join(table.FieldNames(), "`,`") + "`"
+		s := "INSERT INTO `" + name + "` (" + fields + ",`" + ValidFromField + "`)"
+		s += " SELECT " + fields + "," + historyNow + " FROM `" + table.Name + "`"
+		_, err = tx.Exec(slog(s))
+		if err != nil {
+			return "", err
+		}
+		return "created history table " + name, nil
+	}
+
+	added := []string{}
+	for _, f := range table.Fields {
+		exists := false
+		for _, c := range columns {
+			if c == f.Name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			_, err = tx.Exec(slog("ALTER TABLE `" + name + "` ADD COLUMN " + historyColDef(f)))
+			if err != nil {
+				return "", err
+			}
+			added = append(added, f.Name)
+		}
+	}
+	if len(added) > 0 {
+		return "added " + strings.Join(added, ", ") + " to history table " + name, nil
+	}
+	return "", nil
+}
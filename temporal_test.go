@@ -0,0 +1,127 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemporal(t *testing.T) {
+	const yaml = `
+tables:
+  invoice:
+    temporal: true
+    id:
+    customer:
+  invoiceItem:
+    temporal: true
+    id:
+    invoiceId:
+      type: integer
+      ref: invoice.id/customer
+    item:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Referencing tables must be temporal too
+	_, err = NewConfigFromYaml([]byte(strings.Replace(yaml, "    temporal: true\n    id:\n    invoiceId:", "    id:\n    invoiceId:", 1)))
+	assert.EqualError(t, err, "invoiceItem: must be temporal as it references invoice")
+	assert.Nil(t, db.dbInfo.GetTableInfo(HistoryTable("invoice")))
+
+	pause := func() time.Time {
+		time.Sleep(10 * time.Millisecond)
+		defer time.Sleep(10 * time.Millisecond)
+		return time.Now()
+	}
+
+	t0 := pause()
+
+	id, err := db.InsertMap("invoice", map[string]interface{}{
+		"customer": "Fred Blogs",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item A"},
+			{"item": "Item B"},
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	t1 := pause()
+
+	assert.NoError(t, db.UpdateMap("invoice", map[string]interface{}{
+		"id":       id,
+		"customer": "ACME",
+		"invoiceItem_RefTable": []map[string]interface{}{
+			{"item": "Item C"},
+		},
+	}, nil))
+
+	t2 := pause()
+
+	assert.NoError(t, db.Delete("invoice", id, nil))
+
+	_, err = db.GetMapWithOptions("invoice", id, GetOptions{AsOf: t0})
+	assert.Error(t, err)
+
+	m, err := db.GetMapWithOptions("invoice", id, GetOptions{AsOf: t1, WithRefTables: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Fred Blogs", m["customer"])
+	assert.Len(t, m["invoiceItem_RefTable"], 2)
+
+	m, err = db.GetMapWithOptions("invoice", id, GetOptions{AsOf: t2, WithRefTables: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "ACME", m["customer"])
+	assert.Len(t, m["invoiceItem_RefTable"], 1)
+
+	_, err = db.GetMap("invoice", id, false)
+	assert.Error(t, err)
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	asOf := url.QueryEscape(t1.UTC().Format(time.RFC3339Nano))
+
+	res, err := http.Get(ts.URL + "/invoiceItem?asOf=" + asOf)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	rows := make([]map[string]interface{}, 0)
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&rows))
+	res.Body.Close()
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, "Fred Blogs", rows[0]["invoiceId_RefLabel"])
+	}
+
+	res, err = http.Get(ts.URL + "/invoice/1?asOf=" + asOf)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	res, err = http.Get(ts.URL + "/invoice?asOf=yesterday")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res.Body.Close()
+
+	// Adding a field to a temporal table adds it to the history table
+	db2, err := NewDatabase("file::memory:", YamlConfig([]byte(yaml)))
+	assert.NoError(t, err)
+	defer db2.Close()
+	cfg, err := NewConfigFromYaml([]byte(yaml + "    qty:\n      type: integer\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, db2.ApplyConfig(cfg, nil))
+	_, err = db2.InsertMap("invoiceItem", map[string]interface{}{"item": "Item D", "qty": 2}, nil)
+	assert.NoError(t, err)
+	var qty int
+	assert.NoError(t, db2.DB.Get(&qty, "SELECT qty FROM "+HistoryTable("invoiceItem")))
+	assert.Equal(t, 2, qty)
+}
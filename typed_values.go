@@ -0,0 +1,156 @@
+package sqliteapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const dateFormat = "2006-01-02"
+
+type columnTyper interface {
+	ColumnTypes() ([]*sql.ColumnType, error)
+}
+
+// declTypes returns the declared type of each column, being the field type for table
+// columns (including through views and joins) and "" for expressions
+func declTypes(r columnTyper) ([]string, error) {
+	cts, err := r.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(cts))
+	for i, ct := range cts {
+		ret[i] = strings.ToUpper(ct.DatabaseTypeName())
+	}
+	return ret, nil
+}
+
+// columnTypes returns the declared type of each column by name
+func columnTypes(r interface {
+	columnTyper
+	Columns() ([]string, error)
+}) (map[string]string, error) {
+	cols, err := r.Columns()
+	if err != nil {
+		return nil, err
+	}
+	types, err := declTypes(r)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string, len(cols))
+	for i, c := range cols {
+		ret[c] = types[i]
+	}
+	return ret, nil
+}
+
+// typeMap converts the values of a scanned row with typedValue
+func typeMap(types map[string]string, m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = typedValue(types[k], v)
+	}
+}
+
+// typeSlice converts the values of a scanned row with typedValue
+func typeSlice(types []string, s []interface{}) {
+	for i, v := range s {
+		s[i] = typedValue(types[i], v)
+	}
+}
+
+// typedValue returns the scanned value of a column with the declared type as it should
+// be encoded in json: booleans as true/false, dates and datetimes as ISO-8601, integers
+// as numbers and json as embedded json. Other text is returned as a string, rather than
+// []byte which would be base64 encoded.
+func typedValue(declType string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch declType {
+	case "BOOLEAN", "BOOL":
+		switch x := v.(type) {
+		case int64:
+			return x != 0
+		case float64:
+			return x != 0
+		case string, []byte:
+			if b, err := toBool(toString(x)); err == nil {
+				return b
+			}
+		}
+
+	case "DATE":
+		switch x := v.(type) {
+		case time.Time:
+			return x.Format(dateFormat)
+		case string, []byte:
+			if t, ok := parseSqliteTime(toString(x)); ok {
+				return t.Format(dateFormat)
+			}
+		}
+
+	case "DATETIME", "TIMESTAMP":
+		switch x := v.(type) {
+		case string, []byte:
+			if t, ok := parseSqliteTime(toString(x)); ok {
+				return t
+			}
+		}
+
+	case "JSON":
+		switch x := v.(type) {
+		case string, []byte:
+			b := []byte(toString(x))
+			if json.Valid(b) {
+				return json.RawMessage(b)
+			}
+		}
+
+	case "BLOB":
+		return v
+	}
+
+	if strings.Contains(declType, "INT") {
+		switch x := v.(type) {
+		case float64:
+			if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+				return int64(x)
+			}
+		case string, []byte:
+			if i, err := strconv.ParseInt(toString(x), 10, 64); err == nil {
+				return i
+			}
+		}
+	}
+
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+func toString(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// parseSqliteTime parses the time in any of the formats SQLite accepts
+func parseSqliteTime(s string) (time.Time, bool) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
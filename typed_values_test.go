@@ -0,0 +1,88 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedJson(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+  invoice:
+    id:
+    customerId:
+      type: integer
+      ref: customer.id/name
+    paid:
+      type: boolean
+    due:
+      type: date
+    sentAt:
+      type: datetime
+    lines:
+      type: integer
+    attrs:
+      type: json
+    note:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.DB.Exec("INSERT INTO customer (name) VALUES ('Fred')")
+	assert.NoError(t, err)
+	// Values as they may be stored by other writers
+	_, err = db.DB.Exec(`INSERT INTO invoice (customerId, paid, due, sentAt, lines, attrs, note)
+		VALUES (1, 'true', '2026-10-18', '2026-10-18 10:11:12', '12.0', '{"colour":"red","tags":[1,2]}', CAST('Hello' AS BLOB))`)
+	assert.NoError(t, err)
+
+	const expected = `{"customerId":1,"customerId_RefLabel":"Fred","paid":true,"due":"2026-10-18",` +
+		`"sentAt":"2026-10-18T10:11:12Z","lines":12,"attrs":{"colour":"red","tags":[1,2]},"note":"Hello"}`
+	expectedRow := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(expected), &expectedRow))
+
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+	get := func(url string) []byte {
+		res, err := http.Get(ts.URL + url)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return b
+	}
+
+	var rows []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(get("/invoice?select=customerId,paid,due,sentAt,lines,attrs,note"), &rows))
+	assert.Equal(t, []map[string]interface{}{expectedRow}, rows)
+
+	assert.JSONEq(t, `[[true,"2026-10-18",12,{"colour":"red","tags":[1,2]},"Hello"]]`,
+		string(get("/invoice?select=paid,due,lines,attrs,note&format=array")))
+
+	m, err := db.GetMap("customer", 1, true)
+	assert.NoError(t, err)
+	b, err := json.Marshal(m["invoice_RefTable"])
+	assert.NoError(t, err)
+	var refRows []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &refRows))
+	delete(refRows[0], "id")
+	// The label of the referenced row is left out
+	delete(expectedRow, "customerId_RefLabel")
+	assert.Equal(t, []map[string]interface{}{expectedRow}, refRows)
+
+	m, err = db.GetMap("invoice", 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, true, m["paid"])
+	assert.Equal(t, int64(12), m["lines"])
+	assert.Equal(t, "Hello", m["note"])
+	assert.Equal(t, json.RawMessage(`{"colour":"red","tags":[1,2]}`), m["attrs"])
+}
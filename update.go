@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func (d *Database) UpdateMap(table string, data map[string]interface{}, user User) error {
@@ -17,12 +19,56 @@ func (d *Database) UpdateMap(table string, data map[string]interface{}, user Use
 		return ErrUnknownTable
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), d.timeout)
-	tx, err := d.DB.BeginTxx(ctx, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
 	if err != nil {
 		logf("error starting transaction: %s", err)
 		return err
 	}
+	defer done()
+
+	key, err := d.updateMapWithTx(tx, table, data, user)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	done()
+	if err != nil {
+		logf("error committing: %s", err)
+		tx.Rollback()
+		return err
+	}
+
+	err = d.runAfterHooks(table, HookParams{table, key, data, HookAfterUpdate, tx, user})
+	if err != nil {
+		logf("error running after hook: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// updateMapWithTx runs the before update hook and updates the row (and any
+// xxx_RefTable rows) using the given transaction, returning the key value
+func (d *Database) updateMapWithTx(tx *sqlx.Tx, table string, data map[string]interface{}, user User) (interface{}, error) {
+	return d.updateMapWithTxAudit(tx, table, data, user, AuditUpdate)
+}
+
+// updateMapWithTxAudit is updateMapWithTx recording the change with the given audit action
+func (d *Database) updateMapWithTxAudit(tx *sqlx.Tx, table string, data map[string]interface{}, user User, auditAction string) (interface{}, error) {
+	logf := func(format string, args ...interface{}) {
+		d.debugLog.Printf("updateMap: "+format, args...)
+	}
+
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return nil, ErrUnknownTable
+	}
+
+	var err error
 
 	fields := []string{}           // Fields to set
 	pks := []string{}              // Primary keys
@@ -38,56 +84,76 @@ func (d *Database) UpdateMap(table string, data map[string]interface{}, user Use
 				} else if d.IsFieldWritable(table, k) { // And are writable
 					err = d.FieldValidation(table, k, v)
 					if err != nil {
-						return err
+						return nil, err
 					}
-					fieldValues = append(fieldValues, v)
+					fieldValues = append(fieldValues, d.fieldValue(table, k, v))
 					fields = append(fields, k)
 				}
 			}
 		}
 	}
 	if len(fields) == 0 {
-		tx.Rollback()
-		return errors.New("no values to store")
+		return nil, errors.New("no values to store")
 	}
 	if len(pks) == 0 {
-		tx.Rollback()
-		return errors.New("no primary key fields")
+		return nil, errors.New("no primary key fields")
 	}
-	// @todo check enough pk's?
+	if len(pks) != len(tableInfo.GetPrimaryKeyFields()) {
+		return nil, fmt.Errorf("%w: expected values for %s", ErrInvalidKey, strings.Join(tableInfo.GetPrimaryKeyFields(), ","))
+	}
+
+	key := tableInfo.GetKey(data)
 
-	err = d.runHooks(table, HookParams{table, pks[0], data, HookBeforeUpdate, tx, user})
+	err = d.runHooks(table, HookParams{table, key, data, HookBeforeUpdate, tx, user})
 	if err != nil {
 		logf("error running before insert hook: %s", err)
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
 	sql := "UPDATE `" + table + "`"
 	sql += " SET " + strings.Join(fields, "=?,") + "=?"
 	sql += " WHERE " + strings.Join(pks, "=? AND ") + "=?"
+	if d.isSoftDelete(table) { // Deleted rows must be restored before being updated
+		sql += " AND `" + DeletedAtField + "` ISNULL"
+	}
 
 	args := append(fieldValues, pkValues...)
 
 	logf("SQL: %s\nArgs: %s", sql, args)
 
-	res, err := tx.Exec(sql, args...)
+	err = d.auditWithTx(tx, table, auditAction, strings.Join(pks, "=? AND ")+"=?", pkValues, user, func() error {
+		res, err := tx.Exec(sql, args...)
+		if err != nil {
+			logf("error executing sql: %s", err)
+			return err
+		}
+		if i, _ := res.RowsAffected(); i != 1 {
+			return ErrUnknownKey
+		}
+		return nil
+	})
 	if err != nil {
-		logf("error executing sql: %s", err)
-		tx.Rollback()
-		return err
+		return nil, err
 	}
-	if i, _ := res.RowsAffected(); i != 1 {
-		logf("")
-		tx.Rollback()
-		return ErrUnknownKey
+
+	err = d.replaceRefTablesWithTx(tx, table, data, user)
+	if err != nil {
+		return nil, err
 	}
 
-	// Handle reference tables
-	for _, ref := range d.config.GetBackReferences(table) {
+	return key, nil
+}
+
+// replaceRefTablesWithTx replaces all the rows of each xxx_RefTable given in data
+func (d *Database) replaceRefTablesWithTx(tx *sqlx.Tx, table string, data map[string]interface{}, user User) error {
+	for _, ref := range d.getConfig().GetBackReferences(table) {
 		if jdata, ok := data[ref.SourceTable+RefTableSuffix]; ok {
 			if w, ok := data[ref.KeyField]; ok {
-				_, err := tx.Exec("DELETE FROM "+ref.SourceTable+" WHERE "+ref.SourceField+"=?", w)
+				where := "`" + ref.SourceField + "`=?"
+				err := d.auditWithTx(tx, ref.SourceTable, AuditDelete, where, []interface{}{w}, user, func() error {
+					_, err := tx.Exec("DELETE FROM `"+ref.SourceTable+"` WHERE "+where, w)
+					return err
+				})
 				if err != nil {
 					return err
 				}
@@ -106,20 +172,5 @@ func (d *Database) UpdateMap(table string, data map[string]interface{}, user Use
 			}
 		}
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		logf("error committing: %s", err)
-		tx.Rollback()
-		return err
-	}
-
-	err = d.runHooks(table, HookParams{table, pks[0], data, HookAfterUpdate, tx, user})
-	if err != nil {
-		logf("error running after hook: %s", err)
-		tx.Rollback()
-		return err
-	}
-
 	return nil
 }
@@ -109,3 +109,28 @@ tables:
 	assert.Error(t, err)
 
 }
+
+func TestUpdateHookKey(t *testing.T) {
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(`
+tables:
+  customer:
+    id:
+    name:
+`)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	id, err := db.InsertMap("customer", map[string]interface{}{"name": "Fred"}, nil)
+	assert.NoError(t, err)
+
+	keys := []interface{}{}
+	db.AddHook("customer", func(p HookParams) error {
+		keys = append(keys, p.Key)
+		return nil
+	})
+	assert.NoError(t, db.UpdateMap("customer", map[string]interface{}{"id": id, "name": "Bert"}, nil))
+	// The key value, not the name of the key field
+	assert.Equal(t, []interface{}{id, id}, keys)
+}
@@ -0,0 +1,242 @@
+package sqliteapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// UpsertMap inserts the map, or updates the existing row if one already exists with
+// the same values in conflictFields (which must be the primary key or have a unique
+// index). Returns the rowid and true if a row was inserted. The insert or update hooks
+// are run depending on which it was, the before hook being given the conflict values
+// as the key, and xxx_RefTable data replaces existing rows.
+func (d *Database) UpsertMap(table string, data map[string]interface{}, conflictFields []string, user User) (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, done, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer done()
+
+	id, inserted, err := d.upsertMapWithTx(tx, table, data, conflictFields, user)
+	if err != nil {
+		tx.Rollback()
+		return 0, false, err
+	}
+
+	err = tx.Commit()
+	done()
+	if err != nil {
+		return 0, false, err
+	}
+
+	action := HookAfterUpdate
+	if inserted {
+		action = HookAfterInsert
+	}
+	err = d.runAfterHooks(table, HookParams{table, id, data, action, tx, user})
+	if err != nil {
+		d.log.Printf("error running after upsert hook: %s", err)
+		return 0, false, err
+	}
+
+	return id, inserted, nil
+}
+
+func (d *Database) upsertMapWithTx(tx *sqlx.Tx, table string, data map[string]interface{}, conflictFields []string, user User) (int64, bool, error) {
+	logf := func(format string, args ...interface{}) {
+		d.debugLog.Printf("upsertMap: "+format, args...)
+	}
+
+	tableInfo := d.dbInfo.GetTableInfo(table)
+	if tableInfo == nil {
+		return 0, false, ErrUnknownTable
+	}
+
+	if len(conflictFields) == 0 {
+		return 0, false, errors.New("no conflict fields")
+	}
+
+	// The conflict fields must be given as they identify the row, and are validated as
+	// they are written by an insert. Only primary key fields can be read only, as with
+	// the key of an update.
+	pks := tableInfo.GetPrimaryKeyFields()
+	isPrimaryKey := func(k string) bool {
+		for _, pk := range pks {
+			if pk == k {
+				return true
+			}
+		}
+		return false
+	}
+	conflictValues := make([]interface{}, 0)
+	for _, cf := range conflictFields {
+		if !tableInfo.HasField(cf) {
+			return 0, false, fmt.Errorf("unknown field '%s'", cf)
+		}
+		v, ok := data[cf]
+		if !ok || v == nil {
+			return 0, false, fmt.Errorf("%s: missing value", cf)
+		}
+		if !d.IsFieldWritable(table, cf) && !isPrimaryKey(cf) {
+			return 0, false, fmt.Errorf("%s: read only field", cf)
+		}
+		err := d.FieldValidation(table, cf, v)
+		if err != nil {
+			return 0, false, err
+		}
+		conflictValues = append(conflictValues, v)
+	}
+
+	isConflictField := func(k string) bool {
+		for _, cf := range conflictFields {
+			if cf == k {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Generated fields are always written, even if readonly. A newly generated value is
+	// only inserted, an existing row keeping its own.
+	given := make(map[string]bool)
+	for k, v := range data {
+		given[k] = v != nil && v != ""
+	}
+	generated, err := d.generateFields(table, data)
+	if err != nil {
+		return 0, false, err
+	}
+	isGenerated := func(k string) bool {
+		for _, g := range generated {
+			if g == k {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := make([]string, 0)      // Fields to insert
+	values := make([]interface{}, 0) // The values to fill in the ?'s
+	updates := make([]string, 0)     // Fields to set on conflict
+	for k, v := range data {
+		for _, f := range tableInfo.Fields {
+			if f.Name == k {
+				if isConflictField(k) {
+					fields = append(fields, k)
+					values = append(values, v)
+				} else if d.IsFieldWritable(table, k) || isGenerated(k) {
+					err := d.FieldValidation(table, k, v)
+					if err != nil {
+						return 0, false, err
+					}
+					fields = append(fields, k)
+					v = d.fieldValue(table, k, v)
+					switch v.(type) {
+					case []interface{}:
+						values = append(values, "")
+					default:
+						values = append(values, v)
+					}
+					if d.IsFieldWritable(table, k) && (given[k] || !isGenerated(k)) {
+						updates = append(updates, "`"+k+"`=excluded.`"+k+"`")
+					}
+				}
+			}
+		}
+	}
+
+	where := "`" + strings.Join(conflictFields, "`=? AND `") + "`=?"
+
+	var c int
+	err = tx.Get(&c, "SELECT COUNT(*) FROM `"+table+"` WHERE "+where, conflictValues...)
+	if err != nil {
+		return 0, false, err
+	}
+	inserted := c == 0
+
+	action := HookBeforeUpdate
+	if inserted {
+		action = HookBeforeInsert
+	}
+	err = d.runHooks(table, HookParams{table, keyFromValues(conflictValues), data, action, tx, user})
+	if err != nil {
+		logf("error running before hook: %s", err)
+		return 0, false, err
+	}
+
+	sql := "INSERT INTO `" + table + "`"
+	sql += " (`" + strings.Join(fields, "`,`") + "`)"
+	sql += " VALUES (?" + strings.Repeat(",?", len(values)-1) + ")"
+	sql += " ON CONFLICT (`" + strings.Join(conflictFields, "`,`") + "`)"
+	softDelete := d.isSoftDelete(table)
+	if len(updates) > 0 {
+		sql += " DO UPDATE SET " + strings.Join(updates, ",")
+		if softDelete { // Deleted rows must be restored before being updated
+			sql += " WHERE `" + DeletedAtField + "` ISNULL"
+		}
+	} else {
+		sql += " DO NOTHING"
+	}
+
+	logf("SQL: %s\nArgs: %v\n", sql, values)
+
+	exec := func() error {
+		res, err := tx.Exec(sql, values...)
+		if err != nil {
+			logf("error executing sql: %s", err)
+			return err
+		}
+		if i, _ := res.RowsAffected(); i != 1 && len(updates) > 0 && softDelete {
+			return ErrUnknownKey
+		}
+		return nil
+	}
+	if inserted {
+		err = exec()
+	} else {
+		err = d.auditWithTx(tx, table, AuditUpdate, where, conflictValues, user, exec)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	var id int64
+	err = tx.Get(&id, "SELECT rowid FROM `"+table+"` WHERE "+where, conflictValues...)
+	if err != nil {
+		return 0, false, err
+	}
+	if inserted {
+		err = d.auditInsertWithTx(tx, table, id, user)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	if tableInfo.IsPrimaryKeyId {
+		data["id"] = id
+	}
+	if !inserted {
+		for _, g := range generated {
+			if !given[g] {
+				var v interface{}
+				err = tx.Get(&v, "SELECT `"+g+"` FROM `"+table+"` WHERE rowid=?", id)
+				if err != nil {
+					return 0, false, err
+				}
+				data[g] = v
+			}
+		}
+	}
+
+	err = d.replaceRefTablesWithTx(tx, table, data, user)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, inserted, nil
+}
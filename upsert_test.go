@@ -0,0 +1,171 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpsert(t *testing.T) {
+	const yaml = `
+tables:
+  product:
+    id:
+    code:
+      unique: true
+      notnull: true
+      max: 4
+    name:
+      min: 2
+    sku:
+      unique: true
+      readonly: true
+  productTag:
+    id:
+    productId:
+      type: integer
+      ref: product.id/name
+    tag:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+		// Log(log.Default()),
+		// DebugLog(log.Default()),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	actions := make([]HookAction, 0)
+	keys := make([]interface{}, 0)
+	db.AddHook("product", func(p HookParams) error {
+		actions = append(actions, p.Action)
+		keys = append(keys, p.Key)
+		return nil
+	})
+
+	id, inserted, err := db.UpsertMap("product", map[string]interface{}{
+		"code": "A1",
+		"name": "Apple",
+		"productTag_RefTable": []map[string]interface{}{
+			{"tag": "fruit"},
+			{"tag": "red"},
+		},
+	}, []string{"code"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	assert.Equal(t, int64(1), id)
+
+	id, inserted, err = db.UpsertMap("product", map[string]interface{}{
+		"code": "A1",
+		"name": "Green apple",
+		"productTag_RefTable": []map[string]interface{}{
+			{"tag": "green"},
+		},
+	}, []string{"code"}, nil)
+	assert.NoError(t, err)
+	assert.False(t, inserted)
+	assert.Equal(t, int64(1), id)
+
+	assert.Equal(t, []HookAction{HookBeforeInsert, HookAfterInsert, HookBeforeUpdate, HookAfterUpdate}, actions)
+	// The before hooks are given the conflict key, the after hooks the rowid
+	assert.Equal(t, []interface{}{"A1", int64(1), "A1", int64(1)}, keys)
+
+	m, err := db.GetMap("product", 1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "Green apple", m["name"])
+	tags := m["productTag_RefTable"].([]map[string]interface{})
+	assert.Len(t, tags, 1)
+	assert.Equal(t, "green", tags[0]["tag"])
+
+	// Validation
+	_, _, err = db.UpsertMap("product", map[string]interface{}{"code": "B1", "name": "B"}, []string{"code"}, nil)
+	assert.Error(t, err)
+
+	// Missing conflict value
+	_, _, err = db.UpsertMap("product", map[string]interface{}{"name": "Banana"}, []string{"code"}, nil)
+	assert.Error(t, err)
+
+	// Conflict fields are validated and must be writable
+	_, _, err = db.UpsertMap("product", map[string]interface{}{"code": "TOO LONG", "name": "Banana"}, []string{"code"}, nil)
+	assert.ErrorContains(t, err, "code: too long")
+	_, _, err = db.UpsertMap("product", map[string]interface{}{"code": "B1", "sku": "X", "name": "Banana"}, []string{"sku"}, nil)
+	assert.EqualError(t, err, "sku: read only field")
+
+	// Over http
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/product?upsert=code", "application/json",
+		bytes.NewBufferString(`{"code":"A1","name":"Red apple"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	m, err = db.GetMap("product", 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Red apple", m["name"])
+
+	client := &http.Client{}
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/product/5?upsert", bytes.NewBufferString(`{"code":"C1","name":"Cherry"}`))
+	assert.NoError(t, err)
+	res, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	res.Body.Close()
+
+	m, err = db.GetMap("product", 5, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Cherry", m["name"])
+}
+
+func TestUpsertGeneratedKey(t *testing.T) {
+	const yaml = `
+tables:
+  customer:
+    id:
+      type: text
+      generate: uuid
+    code:
+      unique: true
+    name:
+`
+	db, err := NewDatabase("file::memory:",
+		YamlConfig([]byte(yaml)),
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	data := map[string]interface{}{"code": "C1", "name": "ACME"}
+	_, inserted, err := db.UpsertMap("customer", data, []string{"code"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	key, ok := data["id"].(string)
+	assert.True(t, ok)
+	assert.Len(t, key, 36)
+
+	// The existing row keeps its key
+	data = map[string]interface{}{"code": "C1", "name": "ACME Ltd"}
+	_, inserted, err = db.UpsertMap("customer", data, []string{"code"}, nil)
+	assert.NoError(t, err)
+	assert.False(t, inserted)
+	assert.Equal(t, key, data["id"])
+
+	m, err := db.GetMap("customer", key, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACME Ltd", m["name"])
+
+	// POST returns the key of the upserted row
+	ts := httptest.NewServer(db.Handler(""))
+	defer ts.Close()
+	res, err := http.Post(ts.URL+"/customer?upsert=code", "application/json", bytes.NewBufferString(`{"code":"C1","name":"ACME Inc"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, key, string(b))
+}
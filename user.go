@@ -1,5 +1,7 @@
 package sqliteapi
 
+import "net/http"
+
 type SimpleUser struct {
 }
 
@@ -14,3 +16,13 @@ func (u *SimpleUser) IsAdmin() bool {
 func (u *SimpleUser) GetUsername() string {
 	return ""
 }
+
+// isAdminRequest returns true only if the request is by an admin user, as found by the
+// RequestUser option
+func (d *Database) isAdminRequest(r *http.Request) bool {
+	if d.requestUser == nil {
+		return false
+	}
+	user := d.requestUser(r)
+	return user != nil && user.IsAdmin()
+}
@@ -0,0 +1,374 @@
+package sqliteapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const WebhookQueueCreateSql = `
+CREATE TABLE IF NOT EXISTS "gdb_webhook_queue" (
+	"id"				INTEGER	PRIMARY KEY AUTOINCREMENT,
+	"createdAt"		DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	"webhook"			TEXT NOT NULL,
+	"url"				TEXT NOT NULL,
+	"payload"			TEXT NOT NULL,
+	"status"			TEXT NOT NULL DEFAULT 'pending',
+	"attempts"		INTEGER NOT NULL DEFAULT 0,
+	"nextAttemptAt"	DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	"lastError"		TEXT NOT NULL DEFAULT '',
+	"deliveredAt"		DATETIME
+);
+CREATE INDEX IF NOT EXISTS "gdb_webhook_queue_due" ON "gdb_webhook_queue" ("status", "nextAttemptAt");`
+
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead" // Failed maxAttempts times
+)
+
+// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of the payload, prefixed
+// with "sha256=", when the webhook has a secret
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+var (
+	// WebhookClient is used to deliver webhooks
+	WebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+	// WebhookPollInterval is how often the queue is checked for deliveries that are due
+	WebhookPollInterval = time.Second
+
+	// WebhookBackoff is the delay before the first retry, doubling for each failed
+	// attempt up to WebhookMaxBackoff
+	WebhookBackoff    = 5 * time.Second
+	WebhookMaxBackoff = time.Hour
+
+	// WebhookMaxAttempts is the default number of attempts before a delivery is dead
+	WebhookMaxAttempts = 8
+)
+
+// WebhookPayload is the json POSTed to the webhook url
+type WebhookPayload struct {
+	Webhook string                 `json:"webhook"`
+	Table   string                 `json:"table"`  // Table or function name
+	Action  string                 `json:"action"` // insert, update, delete or function
+	Key     interface{}            `json:"key,omitempty"`
+	Data    map[string]interface{} `json:"data"`
+	Time    time.Time              `json:"time"`
+}
+
+// WebhookDelivery is a row of the webhook queue
+type WebhookDelivery struct {
+	ID            int64           `db:"id" json:"id"`
+	CreatedAt     time.Time       `db:"createdAt" json:"createdAt"`
+	Webhook       string          `db:"webhook" json:"webhook"`
+	URL           string          `db:"url" json:"url"`
+	Payload       json.RawMessage `db:"payload" json:"payload"`
+	Status        string          `db:"status" json:"status"`
+	Attempts      int             `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time       `db:"nextAttemptAt" json:"nextAttemptAt"`
+	LastError     string          `db:"lastError" json:"lastError"`
+	DeliveredAt   *time.Time      `db:"deliveredAt" json:"deliveredAt,omitempty"`
+}
+
+type webhookWorker struct {
+	sync.Mutex // Prevents overlapping deliveries
+	once       sync.Once
+	poke       chan struct{}
+	stop       chan struct{}
+	cancel     context.CancelFunc // Cancels a delivery in progress when stopping
+	wg         sync.WaitGroup
+}
+
+func (w *ConfigWebhook) wants(name string, event string, isFunction bool) bool {
+	names := w.Tables
+	if isFunction {
+		names = w.Functions
+	}
+	found := false
+	for _, n := range names {
+		if n == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Database) hasWebhooks(table string) bool {
+	if d.getConfig() == nil {
+		return false
+	}
+	for _, w := range d.getConfig().Webhooks {
+		for _, t := range w.Tables {
+			if t == table {
+				ti := d.dbInfo.GetTableInfo(table)
+				return ti != nil && !ti.IsView && ti.GetPrimaryKey().Field != "?"
+			}
+		}
+	}
+	return false
+}
+
+// queueWebhooksWithTx queues the deliveries for the change to a row, in the same
+// transaction so they are only sent if the change is committed
+func (d *Database) queueWebhooksWithTx(tx *sqlx.Tx, table string, action string, keyValues []interface{}, before, after map[string]interface{}) error {
+	if !d.hasWebhooks(table) {
+		return nil
+	}
+
+	event := ChangeUpdate
+	data := after
+	switch action {
+	case AuditInsert:
+		event = ChangeInsert
+	case AuditDelete:
+		event = ChangeDelete
+		data = before
+	}
+
+	var key interface{} = keyValues
+	if len(keyValues) == 1 {
+		key = keyValues[0]
+	}
+
+	return d.queueWebhookWithTx(tx, table, event, false, key, d.readableData(table, data))
+}
+
+// queueFunctionWebhooksWithTx queues the deliveries for a function call
+func (d *Database) queueFunctionWebhooksWithTx(tx *sqlx.Tx, function string, data map[string]interface{}) error {
+	return d.queueWebhookWithTx(tx, function, ChangeFunction, true, nil, data)
+}
+
+func (d *Database) queueWebhookWithTx(tx *sqlx.Tx, name string, event string, isFunction bool, key interface{}, data map[string]interface{}) error {
+	if d.getConfig() == nil {
+		return nil
+	}
+	for _, w := range d.getConfig().Webhooks {
+		if !w.wants(name, event, isFunction) {
+			continue
+		}
+		b, err := json.Marshal(WebhookPayload{
+			Webhook: w.Name,
+			Table:   name,
+			Action:  event,
+			Key:     key,
+			Data:    data,
+			Time:    time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("webhook %s: %w", w.Name, err)
+		}
+		_, err = tx.Exec("INSERT INTO gdb_webhook_queue (webhook, url, payload) VALUES (?, ?, ?)", w.Name, w.URL, b)
+		if err != nil {
+			return fmt.Errorf("webhook %s: %w", w.Name, err)
+		}
+	}
+	return nil
+}
+
+// startWebhookWorker starts delivering queued webhooks in the background, until the
+// database is closed
+func (d *Database) startWebhookWorker() {
+	d.webhooks.once.Do(func() {
+		d.webhooks.poke = make(chan struct{}, 1)
+		stop := make(chan struct{})
+		d.webhooks.stop = stop
+		ctx, cancel := context.WithCancel(context.Background())
+		d.webhooks.cancel = cancel
+		d.webhooks.wg.Add(1)
+		go func() {
+			defer d.webhooks.wg.Done()
+			ticker := time.NewTicker(WebhookPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				case <-d.webhooks.poke:
+				}
+				_, err := d.deliverWebhooks(ctx)
+				if err != nil {
+					d.log.Printf("error delivering webhooks: %s", err)
+				}
+			}
+		}()
+	})
+}
+
+// pokeWebhookWorker wakes the worker to deliver newly committed webhooks
+func (d *Database) pokeWebhookWorker() {
+	if d.webhooks.poke != nil {
+		select {
+		case d.webhooks.poke <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *Database) stopWebhookWorker() {
+	if d.webhooks.stop != nil {
+		close(d.webhooks.stop)
+		d.webhooks.cancel()
+		d.webhooks.stop = nil
+		d.webhooks.wg.Wait()
+	}
+}
+
+// DeliverWebhooks attempts the deliveries that are due, returning the number delivered.
+// This is called automatically in the background when the config has webhooks.
+func (d *Database) DeliverWebhooks() (int, error) {
+	return d.deliverWebhooks(context.Background())
+}
+
+// deliverWebhooks attempts the deliveries that are due until ctx is cancelled, leaving
+// the rest to be attempted again
+func (d *Database) deliverWebhooks(ctx context.Context) (int, error) {
+	d.webhooks.Lock()
+	defer d.webhooks.Unlock()
+
+	due := make([]WebhookDelivery, 0)
+	err := d.DB.Select(&due, "SELECT * FROM gdb_webhook_queue WHERE status=? AND nextAttemptAt <= strftime('%Y-%m-%d %H:%M:%f', 'now') ORDER BY id LIMIT 100", WebhookPending)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, wd := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		err = d.deliverWebhook(ctx, wd)
+		if err != nil && ctx.Err() != nil {
+			break // Stopped, so not counted as an attempt
+		}
+		if err == nil {
+			delivered++
+			_, err = d.execWrite("UPDATE gdb_webhook_queue SET status=?, attempts=attempts+1, lastError='', deliveredAt=strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id=?",
+				WebhookDelivered, wd.ID)
+			if err != nil {
+				return delivered, err
+			}
+			continue
+		}
+
+		d.log.Printf("webhook %s: delivery %d failed: %s", wd.Webhook, wd.ID, err)
+		attempts := wd.Attempts + 1
+		status := WebhookPending
+		if attempts >= d.webhookMaxAttempts(wd.Webhook) {
+			status = WebhookDead
+		}
+		backoff := WebhookBackoff << (attempts - 1)
+		if backoff > WebhookMaxBackoff || backoff <= 0 {
+			backoff = WebhookMaxBackoff
+		}
+		_, err = d.execWrite("UPDATE gdb_webhook_queue SET status=?, attempts=?, lastError=?, nextAttemptAt=strftime('%Y-%m-%d %H:%M:%f', 'now', ?) WHERE id=?",
+			status, attempts, err.Error(), fmt.Sprintf("+%f seconds", backoff.Seconds()), wd.ID)
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+func (d *Database) webhookMaxAttempts(name string) int {
+	if d.getConfig() != nil {
+		for _, w := range d.getConfig().Webhooks {
+			if w.Name == name && w.MaxAttempts > 0 {
+				return w.MaxAttempts
+			}
+		}
+	}
+	return WebhookMaxAttempts
+}
+
+func (d *Database) deliverWebhook(ctx context.Context, wd WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wd.URL, bytes.NewReader(wd.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", fmt.Sprintf("%d", wd.ID))
+	if d.getConfig() != nil {
+		for _, w := range d.getConfig().Webhooks {
+			if w.Name == wd.Webhook && w.Secret != "" {
+				req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(w.Secret, wd.Payload))
+			}
+		}
+	}
+
+	res, err := WebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("response status %s", res.Status)
+	}
+	return nil
+}
+
+// WebhookSignature returns the hex encoded HMAC-SHA256 of the payload, for receivers
+// to check the X-Webhook-Signature header
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookQueue returns the queued deliveries with the given status (or all if ""),
+// newest first
+func (d *Database) WebhookQueue(status string, limit int) ([]WebhookDelivery, error) {
+	q := "SELECT * FROM gdb_webhook_queue"
+	args := []interface{}{}
+	if status != "" {
+		q += " WHERE status=?"
+		args = append(args, status)
+	}
+	q += " ORDER BY id DESC"
+	if limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	ret := make([]WebhookDelivery, 0)
+	err := d.DB.Select(&ret, q, args...)
+	return ret, err
+}
+
+// RedeliverWebhook queues the delivery to be sent again now, e.g. once dead
+func (d *Database) RedeliverWebhook(id int64) error {
+	res, err := d.execWrite("UPDATE gdb_webhook_queue SET status=?, attempts=0, nextAttemptAt=strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id=?",
+		WebhookPending, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUnknownKey
+	}
+	d.pokeWebhookWorker()
+	return nil
+}
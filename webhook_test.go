@@ -0,0 +1,234 @@
+package sqliteapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	defer func(poll, backoff time.Duration) {
+		WebhookPollInterval = poll
+		WebhookBackoff = backoff
+	}(WebhookPollInterval, WebhookBackoff)
+	WebhookPollInterval = 10 * time.Millisecond
+	WebhookBackoff = 10 * time.Millisecond
+
+	var failing int32
+	received := make(chan WebhookPayload, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+WebhookSignature("s3cret", b), r.Header.Get(WebhookSignatureHeader))
+		assert.NotEmpty(t, r.Header.Get("X-Webhook-Id"))
+		var p WebhookPayload
+		assert.NoError(t, json.Unmarshal(b, &p))
+		received <- p
+	}))
+	defer ts.Close()
+
+	// The queue is delivered from another connection, so the memory database must be shared
+	db, err := NewDatabase("file:TestWebhooks?mode=memory&cache=shared",
+		YamlConfig([]byte(`
+tables:
+  invoice:
+    id:
+    total:
+      type: integer
+    paid:
+      type: boolean
+    password:
+      hidden: true
+  note:
+    id:
+    text:
+functions:
+  markPaid:
+    params:
+      invoiceId:
+    statements:
+      - UPDATE invoice SET paid=1 WHERE id=$invoiceId
+webhooks:
+  accounts:
+    url: `+ts.URL+`
+    tables: [invoice]
+    functions: [markPaid]
+    secret: s3cret
+    maxAttempts: 3
+`)),
+		asAdmin,
+	)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	next := func() WebhookPayload {
+		select {
+		case p := <-received:
+			return p
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for webhook")
+		}
+		return WebhookPayload{}
+	}
+
+	id, err := db.InsertMap("invoice", map[string]interface{}{"total": 10, "password": "xyz"}, nil)
+	assert.NoError(t, err)
+	p := next()
+	assert.Equal(t, "accounts", p.Webhook)
+	assert.Equal(t, "invoice", p.Table)
+	assert.Equal(t, ChangeInsert, p.Action)
+	assert.Equal(t, float64(id), p.Key)
+	assert.Equal(t, float64(10), p.Data["total"])
+	assert.NotContains(t, p.Data, "password")
+
+	// Tables without webhooks are not queued
+	_, err = db.InsertMap("note", map[string]interface{}{"text": "hello"}, nil)
+	assert.NoError(t, err)
+
+	// Nothing is queued when the transaction is rolled back
+	_, err = db.Batch([]BatchOp{
+		{Op: BatchInsert, Table: "invoice", Data: map[string]interface{}{"total": 20}},
+		{Op: BatchUpdate, Table: "invoice", Key: 999, Data: map[string]interface{}{"total": 30}},
+	}, nil)
+	assert.Error(t, err)
+	queue, err := db.WebhookQueue("", 0)
+	assert.NoError(t, err)
+	assert.Len(t, queue, 1)
+
+	assert.NoError(t, db.CallFunction("markPaid", map[string]interface{}{"invoiceId": id}, nil))
+	p = next()
+	assert.Equal(t, ChangeFunction, p.Action)
+	assert.Equal(t, "markPaid", p.Table)
+	assert.Equal(t, float64(id), p.Data["invoiceId"])
+
+	assert.NoError(t, db.Delete("invoice", id, nil))
+	p = next()
+	assert.Equal(t, ChangeDelete, p.Action)
+	assert.Equal(t, float64(10), p.Data["total"])
+
+	// Failed deliveries are retried, then dead after maxAttempts
+	atomic.StoreInt32(&failing, 1)
+	id, err = db.InsertMap("invoice", map[string]interface{}{"total": 40}, nil)
+	assert.NoError(t, err)
+	var dead []WebhookDelivery
+	assert.Eventually(t, func() bool {
+		dead, err = db.WebhookQueue(WebhookDead, 0)
+		return err == nil && len(dead) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, 3, dead[0].Attempts)
+		assert.Contains(t, dead[0].LastError, "503")
+	}
+
+	dbServer := httptest.NewServer(db.Handler(""))
+	defer dbServer.Close()
+
+	// Admin only
+	db.requestUser = func(r *http.Request) User { return testUser("fred") }
+	res, err := http.Get(dbServer.URL + "/_webhooks?status=dead")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	res, err = http.Post(dbServer.URL+fmt.Sprintf("/_webhooks/%d?redeliver", dead[0].ID), "", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	db.requestUser = func(r *http.Request) User { return testAdmin("admin") }
+
+	res, err = http.Get(dbServer.URL + "/_webhooks?status=dead")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var listed []WebhookDelivery
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+	res.Body.Close()
+	assert.Len(t, listed, 1)
+
+	// Redelivery once the receiver is back
+	atomic.StoreInt32(&failing, 0)
+	res, err = http.Post(dbServer.URL+"/_webhooks/999?redeliver", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, err = http.Post(dbServer.URL+fmt.Sprintf("/_webhooks/%d?redeliver", dead[0].ID), "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	p = next()
+	assert.Equal(t, float64(id), p.Key)
+
+	assert.Eventually(t, func() bool {
+		queue, err = db.WebhookQueue(WebhookDelivered, 0)
+		return err == nil && len(queue) == 4
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Closing again is harmless
+	db.Close()
+}
+
+func TestWebhooksStop(t *testing.T) {
+	defer func(poll time.Duration) {
+		WebhookPollInterval = poll
+	}(WebhookPollInterval)
+	WebhookPollInterval = 10 * time.Millisecond
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	file := filepath.Join(t.TempDir(), "webhooks.db")
+	db, err := NewDatabase("file:"+file,
+		YamlConfig([]byte(`
+tables:
+  invoice:
+    id:
+    total:
+      type: integer
+webhooks:
+  accounts:
+    url: `+ts.URL+`
+    tables: [invoice]
+`)),
+	)
+	assert.NoError(t, err)
+
+	_, err = db.InsertMap("invoice", map[string]interface{}{"total": 10}, nil)
+	assert.NoError(t, err)
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for webhook")
+	}
+
+	// Closing cancels the delivery in progress, which is left to be attempted again
+	closed := time.Now()
+	db.Close()
+	assert.Less(t, int64(time.Since(closed)), int64(time.Second))
+
+	conn, err := sqlx.Open("sqlite3", "file:"+file)
+	assert.NoError(t, err)
+	defer conn.Close()
+	var queue []WebhookDelivery
+	assert.NoError(t, conn.Select(&queue, "SELECT * FROM gdb_webhook_queue"))
+	if assert.Len(t, queue, 1) {
+		assert.Equal(t, WebhookPending, queue[0].Status)
+		assert.Equal(t, 0, queue[0].Attempts)
+	}
+}